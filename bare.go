@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Kinds of repositories created by clone, see Invocation.CloneMode
+const (
+	CloneBare   = "bare"   // Bare repository, without a work tree
+	CloneMirror = "mirror" // Bare repository mirroring all the refs of its remote
+)
+
+// git commands that need a work tree, and cannot run in a bare repository
+var workTreeCommands = map[string]bool{
+	"add":             true,
+	"am":              true,
+	"checkout":        true,
+	"cherry-pick":     true,
+	"clean":           true,
+	"commit":          true,
+	"merge":           true,
+	"mv":              true,
+	"pull":            true,
+	"rebase":          true,
+	"reset":           true,
+	"restore":         true,
+	"revert":          true,
+	"rm":              true,
+	"sparse-checkout": true,
+	"stash":           true,
+	"status":          true,
+	"switch":          true,
+}
+
+// Check whether git arguments run a command that needs a work tree
+func NeedsWorkTree(args []string) bool {
+	return workTreeCommands[GitSubcommand(args)]
+}
+
+// Check whether a directory is a git directory, i.e. the .git directory of a
+// repository or a bare repository
+// As in git, a git directory has a HEAD file and objects and refs directories.
+func isGitDir(dir string) bool {
+	if info, err := os.Stat(filepath.Join(dir, "HEAD")); err != nil || info.IsDir() {
+		return false
+	}
+	for _, sub := range []string{"objects", "refs"} {
+		if info, err := os.Stat(filepath.Join(dir, sub)); err != nil || !info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// Check whether the repository is bare
+// A repository is bare if it is declared so in repos.json (see Repo.Bare and
+// Repo.Mirror), or if its local path is a git directory rather than a work tree.
+func (r *Repo) IsBare() bool {
+	if r.Bare || r.Mirror {
+		return true
+	}
+	gitDir, _, err := r.ResolveGitDir()
+	return err == nil && gitDir == filepath.Clean(r.Local)
+}
+
+// Return the kind of a bare repository, CloneMirror or CloneBare, empty if it is not bare
+func (r *Repo) BareKind() string {
+	switch {
+	case r.Mirror:
+		return CloneMirror
+	case r.IsBare():
+		return CloneBare
+	}
+	return ""
+}
+
+// Remove the bare repositories from a list, as the command needs a work tree
+// A line is printed on stderr for each repository skipped, with the reason.
+// The missing repositories are kept, so that they are reported as missing.
+func SkipBareRepos(repos []Repo, reason string) []Repo {
+	var kept []Repo
+	for _, repo := range repos {
+		if _, err := os.Stat(repo.Local); err == nil && repo.IsBare() {
+			fmt.Fprintln(os.Stderr, ColorOutput(ColorYellow, fmt.Sprintf("Skipping %s: bare repository, %s", repo.Name, reason)))
+			continue
+		}
+		kept = append(kept, repo)
+	}
+	return kept
+}
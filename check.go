@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Kinds of drift between repos.json and the repositories on disk
+const (
+	DriftMissing       = "missing"          // The local path does not exist
+	DriftNotRepo       = "not a repository" // The local path is not a git repository
+	DriftConfig        = "config"           // The git configuration of the repository cannot be read
+	DriftRemote        = "remote"           // The remotes differ from the declared ones, see DiffRemotes
+	DriftWorktree      = "worktree"         // The repository is not a worktree of the declared repository
+	DriftDuplicateName = "duplicate name"   // Another entry has the same name
+	DriftDuplicatePath = "duplicate path"   // Another entry has the same local path
+)
+
+// Directions in which the remote drifts are fixed, see CheckCommand
+const (
+	FixJSON   = "json"   // repos.json is updated from the git configuration of the repositories
+	FixConfig = "config" // The git configuration of the repositories is updated from repos.json
+)
+
+// Struct Drift is a difference between an entry of repos.json and the repository on disk
+type Drift struct {
+	Kind    string
+	Detail  string
+	Changes []RemoteChange // Differences of the remotes, for DriftRemote
+}
+
+// Compare the repository with its entry in repos.json
+// The duplicates are found separately, as they involve several entries, see FindDuplicates.
+// The repos are the entries of repos.json, to check the main repository of a worktree.
+func (r *Repo) CheckDrift(repos []Repo) []Drift {
+	if _, err := os.Stat(r.Local); os.IsNotExist(err) {
+		return []Drift{{Kind: DriftMissing, Detail: fmt.Sprintf("%s does not exist", r.Local)}}
+	}
+	_, commonDir, err := r.ResolveGitDir()
+	if err != nil {
+		return []Drift{{Kind: DriftNotRepo, Detail: err.Error()}}
+	}
+
+	var drifts []Drift
+	if r.IsWorktree() {
+		parent := FindRepo(repos, r.WorktreeOf)
+		if _, parentDir, err := parent.ResolveGitDir(); err != nil || parentDir != commonDir {
+			drifts = append(drifts, Drift{Kind: DriftWorktree, Detail: fmt.Sprintf("%s is not a worktree of %s", r.Local, parent.Local)})
+		}
+	} else {
+		changes, err := r.DiffRemotes()
+		if err != nil {
+			drifts = append(drifts, Drift{Kind: DriftConfig, Detail: err.Error()})
+		} else if len(changes) > 0 {
+			drifts = append(drifts, Drift{Kind: DriftRemote, Detail: "the git configuration differs from repos.json", Changes: changes})
+		}
+	}
+	return drifts
+}
+
+// Find the entries of repos.json that have the same name or the same local path
+// The paths are compared once cleaned and, when they exist, once their
+// symbolic links are resolved. Returns the drifts of each entry, by index.
+func FindDuplicates(repos []Repo) [][]Drift {
+	drifts := make([][]Drift, len(repos))
+	names := make(map[string]int)
+	paths := make(map[string]int)
+	for i, repo := range repos {
+		if j, ok := names[repo.Name]; ok {
+			drifts[i] = append(drifts[i], Drift{Kind: DriftDuplicateName, Detail: fmt.Sprintf("entry %d has the same name", j+1)})
+		} else {
+			names[repo.Name] = i
+		}
+
+		path := filepath.Clean(repo.Local)
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			path = real
+		}
+		if j, ok := paths[path]; ok {
+			drifts[i] = append(drifts[i], Drift{Kind: DriftDuplicatePath, Detail: fmt.Sprintf("entry %d (%s) has the same local path", j+1, repos[j].Name)})
+		} else {
+			paths[path] = i
+		}
+	}
+	return drifts
+}
+
+// Check whether a list of drifts has one of a kind
+func hasDrift(drifts []Drift, kind string) bool {
+	for _, drift := range drifts {
+		if drift.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Update an entry of repos.json with the remotes of the git configuration, see DiffRemotes
+// The remotes are all declared with Repo.Remotes if the entry already does, or
+// if the primary remote has a push URL; otherwise only the URL of the primary
+// remote is updated.
+func (r *Repo) adoptRemotes(config *GitConfig) {
+	actual := configRemotes(config)
+	primary := actual[r.PrimaryRemote()]
+	if r.Remotes != nil || primary.Push != "" {
+		r.Remotes = actual
+		if r.Remote != "" {
+			r.Remote = primary.Fetch
+		}
+		return
+	}
+	r.Remote = primary.Fetch
+}
+
+// Write the repositories to a repos.json file
+// The configuration of the repositories is not written, see ReposToJSON.
+// The file is replaced atomically, so that it is not left half-written.
+func SaveReposToJSON(file string, repos []Repo) error {
+	data, err := ReposToJSON(repos, false)
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(data+"\n"), 0644); err != nil {
+		return fmt.Errorf("Could not write %s: %s", tmp, err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("Could not replace %s: %s", file, err)
+	}
+	return nil
+}
+
+// Command: check
+// Description: Compare repos.json with the repositories on disk
+// Each entry is checked against its repository (see Repo.CheckDrift), and
+// against the other entries (see FindDuplicates). With fix, FixJSON or
+// FixConfig, the differences of the remotes are fixed in repos.json or in the
+// git configuration of the repositories; the other drifts are only reported.
+// Exits with 1 if drifts remain.
+// Example: gogit check --fix-config @backend
+func CheckCommand(ctx context.Context, repos []Repo, file string, selector string, fix string) {
+	if len(repos) == 0 {
+		fmt.Println(ColorOutput(ColorYellow, "No repositories found"))
+		os.Exit(0)
+	}
+
+	// Filter repositories if a selector is provided
+	// The duplicates are still found among all the entries
+	filteredRepos, err := SelectRepos(repos, selector)
+	if err != nil {
+		fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error: %s", err)))
+		os.Exit(1)
+	}
+	selected := make(map[string]bool)
+	for _, repo := range filteredRepos {
+		selected[repo.Name+"\x00"+repo.Local] = true
+	}
+
+	// The entries as written in repos.json, without the values read from the
+	// repositories, to be updated with FixJSON
+	var entries []Repo
+	if fix == FixJSON {
+		data, err := os.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(data, &entries)
+		}
+		if err == nil && len(entries) != len(repos) {
+			err = fmt.Errorf("the file changed since it was loaded")
+		}
+		if err != nil {
+			fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error reading %s: %s", file, err)))
+			os.Exit(1)
+		}
+	}
+
+	nameWidth := 0
+	for _, repo := range filteredRepos {
+		if len(repo.Name) > nameWidth {
+			nameWidth = len(repo.Name)
+		}
+	}
+
+	duplicates := FindDuplicates(repos)
+	remaining, fixed := 0, 0
+	for i := range repos {
+		repo := &repos[i]
+		if !selected[repo.Name+"\x00"+repo.Local] {
+			continue
+		}
+		name := ColorOutput(ColorCyan, fmt.Sprintf("%-*s", nameWidth, repo.Name))
+		// An entry with the path of a previous one is not compared with the
+		// repository, which is already checked with the previous entry
+		drifts := duplicates[i]
+		if !hasDrift(drifts, DriftDuplicatePath) {
+			drifts = append(drifts, repo.CheckDrift(repos)...)
+		}
+		for _, drift := range drifts {
+			fmt.Printf("%s  %s: %s\n", name, ColorOutput(ColorRed, drift.Kind), drift.Detail)
+			name = strings.Repeat(" ", nameWidth)
+			for _, change := range drift.Changes {
+				fmt.Printf("%s    %s\n", name, change)
+			}
+			if drift.Kind != DriftRemote || fix == "" {
+				remaining++
+				continue
+			}
+
+			switch fix {
+			case FixJSON:
+				entries[i].adoptRemotes(repo.Config)
+				fmt.Printf("%s  %s\n", name, ColorOutput(ColorGreen, fmt.Sprintf("Fixed: updated the remotes in %s", file)))
+				fixed++
+			case FixConfig:
+				var stdout, stderr strings.Builder
+				if err := repo.SyncRemotes(ctx, &stdout, &stderr, false); err != nil {
+					fmt.Printf("%s  %s\n", name, ColorOutput(ColorRed, fmt.Sprintf("Error fixing the remotes: %s %s", err, strings.TrimSpace(stderr.String()))))
+					remaining++
+					continue
+				}
+				fmt.Printf("%s  %s\n", name, ColorOutput(ColorGreen, "Fixed: updated the remotes in the git configuration"))
+				fixed++
+			}
+		}
+	}
+
+	if fix == FixJSON && fixed > 0 {
+		if err := SaveReposToJSON(file, entries); err != nil {
+			fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error: %s", err)))
+			os.Exit(1)
+		}
+	}
+
+	switch {
+	case remaining > 0:
+		fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("%d drifts found, %d fixed", remaining+fixed, fixed)))
+		os.Exit(1)
+	case fixed > 0:
+		fmt.Println(ColorOutput(ColorGreen, fmt.Sprintf("%d drifts found, all fixed", fixed)))
+	default:
+		fmt.Println(ColorOutput(ColorGreen, "repos.json matches the repositories"))
+	}
+	os.Exit(0)
+}
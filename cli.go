@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 const (
@@ -14,21 +17,46 @@ const (
 	ColorWhite = "37"
 )
 
+// Whether ColorOutput colors the messages, disabled with --no-color or the NO_COLOR environment variable
+var ColorEnabled = os.Getenv("NO_COLOR") == ""
+
+// Whether PrintVerbose prints its messages, enabled with --verbose
+var Verbose = false
 
 func ColorOutput(color string, message string) string {
+	if !ColorEnabled {
+		return message
+	}
 	return fmt.Sprintf("\033[%sm%s\033[0m", color, message)
 }
 
+// Print a message on stderr in verbose mode
+func PrintVerbose(format string, args ...interface{}) {
+	if Verbose {
+		fmt.Fprintln(os.Stderr, ColorOutput(ColorMagenta, fmt.Sprintf(format, args...)))
+	}
+}
+
 // Print the details of a Repo on a single line without the Config field
 func PrintRepoSimple(repo *Repo) {
 	repoNameWidth := 30
 	localPathWidth := 50
 	remoteURLWidth := 50
 
-	fmt.Printf("%s: %-*s %s: %-*s %s: %-*s\n",
+	fmt.Printf("%s: %-*s %s: %-*s %s: %-*s",
 		ColorOutput(ColorCyan, "Repo"), repoNameWidth, ColorOutput(ColorGreen, repo.Name),
 		ColorOutput(ColorCyan, "Local"), localPathWidth, ColorOutput(ColorGreen, repo.Local),
 		ColorOutput(ColorCyan, "Remote"), remoteURLWidth, ColorOutput(ColorGreen, repo.Remote))
+	if groups := repo.AllGroups(); len(groups) > 0 {
+		fmt.Printf(" %s: %s", ColorOutput(ColorCyan, "Groups"), ColorOutput(ColorGreen, strings.Join(groups, ", ")))
+	}
+	if repo.IsWorktree() {
+		fmt.Printf(" %s: %s", ColorOutput(ColorCyan, "Worktree of"), ColorOutput(ColorGreen, repo.WorktreeOf))
+	}
+	if kind := repo.BareKind(); kind != "" {
+		fmt.Printf(" %s: %s", ColorOutput(ColorCyan, "Type"), ColorOutput(ColorGreen, kind))
+	}
+	fmt.Println()
 }
 
 
@@ -39,24 +67,56 @@ func PrintRepo(repo *Repo) {
 	fmt.Printf("%s: %s\n", ColorOutput(ColorCyan, "Local Path"), ColorOutput(ColorGreen, repo.Local))
 	if repo.Remote != "" {
 		fmt.Printf("%s: %s\n", ColorOutput(ColorCyan, "Remote URL"), ColorOutput(ColorGreen, repo.Remote))
+		if name := repo.PrimaryRemote(); name != DefaultRemoteName {
+			fmt.Printf("%s: %s\n", ColorOutput(ColorCyan, "Remote Name"), ColorOutput(ColorGreen, name))
+		}
 	}
-	fmt.Println(ColorOutput(ColorCyan, "Config:"))
-	for section, settings := range repo.Config {
-		fmt.Printf("  %s\n", ColorOutput(ColorMagenta, section))
-		switch settings := settings.(type) {
-		case map[string]string:
-			for key, value := range settings {
-				fmt.Printf("    %s: %s\n", ColorOutput(ColorYellow, key), ColorOutput(ColorGreen, value))
+	if len(repo.Remotes) > 0 {
+		fmt.Println(ColorOutput(ColorCyan, "Remotes:"))
+		remotes := repo.DeclaredRemotes()
+		for _, name := range sortedKeys(remotes) {
+			fmt.Printf("  %s: %s\n", ColorOutput(ColorYellow, name), ColorOutput(ColorGreen, remotes[name].Fetch))
+			if push := remotes[name].Push; push != "" {
+				fmt.Printf("  %s: %s %s\n", ColorOutput(ColorYellow, name), ColorOutput(ColorGreen, push), "(push)")
 			}
-		case map[string]interface{}:
-			for subsection, subsettings := range settings {
-				fmt.Printf("    %s\n", ColorOutput(ColorBlue, subsection))
-				if subsettings, ok := subsettings.(map[string]string); ok {
-					for key, value := range subsettings {
-						fmt.Printf("      %s: %s\n", ColorOutput(ColorYellow, key), ColorOutput(ColorGreen, value))
-					}
-				}
+		}
+	}
+	if groups := repo.AllGroups(); len(groups) > 0 {
+		fmt.Printf("%s: %s\n", ColorOutput(ColorCyan, "Groups"), ColorOutput(ColorGreen, strings.Join(groups, ", ")))
+	}
+	if repo.IsWorktree() {
+		fmt.Printf("%s: %s\n", ColorOutput(ColorCyan, "Worktree of"), ColorOutput(ColorGreen, repo.WorktreeOf))
+	}
+	if kind := repo.BareKind(); kind != "" {
+		fmt.Printf("%s: %s\n", ColorOutput(ColorCyan, "Type"), ColorOutput(ColorGreen, kind))
+	}
+	if gitDir, _, err := repo.ResolveGitDir(); err == nil && gitDir != filepath.Join(repo.Local, ".git") && gitDir != filepath.Clean(repo.Local) {
+		fmt.Printf("%s: %s\n", ColorOutput(ColorCyan, "Git Dir"), ColorOutput(ColorGreen, gitDir))
+	}
+	fmt.Println(ColorOutput(ColorCyan, "Config:"))
+	// Entries in the order of the configuration file, multi-valued keys included
+	var section, subsection string
+	var entries []ConfigEntry
+	if repo.Config != nil {
+		entries = repo.Config.Entries
+	}
+	for i, entry := range entries {
+		if i == 0 || entry.Section != section || entry.Subsection != subsection {
+			section, subsection = entry.Section, entry.Subsection
+			if subsection != "" {
+				fmt.Printf("  %s %s\n", ColorOutput(ColorMagenta, section), ColorOutput(ColorBlue, subsection))
+			} else {
+				fmt.Printf("  %s\n", ColorOutput(ColorMagenta, section))
 			}
 		}
+		origin := ""
+		if entry.Origin != "" && entry.Origin != repo.Config.File {
+			origin = " " + ColorOutput(ColorBlue, fmt.Sprintf("(from %s)", entry.Origin))
+		}
+		if entry.NoValue {
+			fmt.Printf("    %s%s\n", ColorOutput(ColorYellow, entry.Key), origin)
+		} else {
+			fmt.Printf("    %s: %s%s\n", ColorOutput(ColorYellow, entry.Key), ColorOutput(ColorGreen, entry.Value), origin)
+		}
 	}
 }
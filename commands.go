@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"sync"
@@ -13,59 +15,81 @@ import (
 // (Default if no command is provided)
 // Print the help message
 // PrintHelp prints the usage and commands with aligned columns and colors
-// PrintHelp prints the usage and commands with aligned columns and colors
+// The help of the commands is generated from AllCommands
 func PrintHelp(command ...string) {
 	if len(command) == 0 {
 		// General help
-		fmt.Println(ColorOutput(ColorYellow, "Usage: gogit <command> [arguments]"))
+		fmt.Println(ColorOutput(ColorYellow, "Usage: gogit [global options] <command> [arguments]"))
 		fmt.Println(ColorOutput(ColorYellow, "Commands:"))
 
 		// Define the widths for each field
-		commandWidth := 40
-
-		fmt.Printf("  %-*s %s\n", commandWidth, ColorOutput(ColorCyan, "list"), ColorOutput(ColorWhite, "List the repositories in a simple and compact format"))
-		fmt.Printf("  %-*s %s\n", commandWidth, ColorOutput(ColorCyan, "list full"), ColorOutput(ColorWhite, "List the repositories in a detailed format"))
-		fmt.Printf("  %-*s %s\n", commandWidth, ColorOutput(ColorCyan, "run <command> [repository]"), ColorOutput(ColorWhite, "Execute a git command on a repository or on all repositories if no repository is provided"))
-		fmt.Printf("  %-*s %s\n", commandWidth, ColorOutput(ColorCyan, "do <command> [repository]"), ColorOutput(ColorWhite, "Execute a predefined command on a repository or on all repositories if no repository is provided."))
-		fmt.Printf("  %-*s %s\n", commandWidth, "", ColorOutput(ColorWhite, "To show all available commands, use 'gogit help do'"))
-		fmt.Printf("  %-*s %s\n", commandWidth, ColorOutput(ColorCyan, "genrepos [root]"), ColorOutput(ColorWhite, "Generate and print a JSON string with the details of all git repositories in a given root folder"))
-		fmt.Printf("  %-*s %s\n", commandWidth, ColorOutput(ColorCyan, "clone"), ColorOutput(ColorWhite, "Check all repositories and clone the ones that are missing"))
-		fmt.Printf("  %-*s %s\n", commandWidth, ColorOutput(ColorCyan, "help [command]"), ColorOutput(ColorWhite, "Print this help message or detailed help for a specific command"))
-	} else {
-		// Detailed help for a specific command
-		cmd := command[0]
-		switch cmd {
-		case "list":
-			fmt.Println(ColorOutput(ColorYellow, "Usage: gogit list [full]"))
-			fmt.Println(ColorOutput(ColorWhite, "List the repositories in a simple and compact format. Use 'full' to list in a detailed format."))
-		case "run":
-			fmt.Println(ColorOutput(ColorYellow, "Usage: gogit run <command> [repository]"))
-			fmt.Println(ColorOutput(ColorWhite, "Execute a git command on a repository or on all repositories if no repository is provided."))
-		case "do":
-			fmt.Println(ColorOutput(ColorYellow, "Usage: gogit do <command> [repository]"))
-			fmt.Println(ColorOutput(ColorWhite, "Show the details of a predefined command on a repository or on all repositories if no repository is provided."))
-			fmt.Println(ColorOutput(ColorWhite, "Available predefined commands:"))
-			commands, err := LoadUserCommands()
-			if err != nil {
-				fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error loading custom commands: %s", err)))
-				commands = predefinedCommands
+		commandWidth := 32
+		for _, cmd := range AllCommands() {
+			for _, usage := range cmd.Usages {
+				if len(cmd.Name)+1+len(usage) > commandWidth {
+					commandWidth = len(cmd.Name) + 1 + len(usage)
+				}
 			}
-			for cmd, args := range commands {
-				// fmt.Printf("  %s\n", ColorOutput(ColorGreen, cmd))
-				fmt.Printf("  %s => %s\n", ColorOutput(ColorGreen, cmd), ColorOutput(ColorBlue, strings.Join(args, " ")))
+		}
+
+		for _, cmd := range AllCommands() {
+			for i, usage := range cmd.Usages {
+				summary := ""
+				if i == 0 {
+					summary = cmd.Summary
+				}
+				label := strings.TrimSpace(cmd.Name + " " + usage)
+				if summary == "" {
+					fmt.Printf("  %s\n", ColorOutput(ColorCyan, label))
+					continue
+				}
+				fmt.Printf("  %s %s\n", ColorOutput(ColorCyan, fmt.Sprintf("%-*s", commandWidth, label)), ColorOutput(ColorWhite, summary))
 			}
-		case "genrepos":
-			fmt.Println(ColorOutput(ColorYellow, "Usage: gogit genrepos [root]"))
-			fmt.Println(ColorOutput(ColorWhite, "Generate and print a JSON string with the details of all git repositories in a given root folder."))
-		case "clone":
-			fmt.Println(ColorOutput(ColorYellow, "Usage: gogit clone"))
-			fmt.Println(ColorOutput(ColorWhite, "Check all repositories and clone the ones that are missing."))
-		case "help":
-			fmt.Println(ColorOutput(ColorYellow, "Usage: gogit help [command]"))
-			fmt.Println(ColorOutput(ColorWhite, "Print this help message or detailed help for a specific command."))
-		default:
-			fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error: Unknown command '%s'", cmd)))
-			fmt.Println(ColorOutput(ColorWhite, "Use 'gogit help' to see the list of available commands."))
+		}
+		fmt.Println(ColorOutput(ColorWhite, "Repositories are selected by name, glob, regular expression, @group or path, see 'gogit help selectors'"))
+		GlobalFlags.PrintHelp()
+		return
+	}
+
+	// Detailed help for a specific command
+	cmd := command[0]
+	if cmd == "selectors" {
+		fmt.Println(ColorOutput(ColorYellow, "Selectors"))
+		fmt.Println(ColorOutput(ColorWhite, "The list, status, do and clone commands accept a selector of repositories as last argument,"))
+		fmt.Println(ColorOutput(ColorWhite, "or with the --repo (-r) option, which run requires for the selectors other than a name or a @group."))
+		fmt.Println(ColorOutput(ColorWhite, "A selector is a comma-separated list of terms:"))
+		termWidth := 32
+		fmt.Printf("  %s %s\n", ColorOutput(ColorCyan, fmt.Sprintf("%-*s", termWidth, "name")), ColorOutput(ColorWhite, "The repository with this name"))
+		fmt.Printf("  %s %s\n", ColorOutput(ColorCyan, fmt.Sprintf("%-*s", termWidth, "api-*")), ColorOutput(ColorWhite, "The repositories whose name matches a shell-style glob"))
+		fmt.Printf("  %s %s\n", ColorOutput(ColorCyan, fmt.Sprintf("%-*s", termWidth, "re:^api-v[0-9]+$")), ColorOutput(ColorWhite, "The repositories whose name matches a regular expression"))
+		fmt.Printf("  %s %s\n", ColorOutput(ColorCyan, fmt.Sprintf("%-*s", termWidth, "@group")), ColorOutput(ColorWhite, "The repositories of a group or with a tag"))
+		fmt.Printf("  %s %s\n", ColorOutput(ColorCyan, fmt.Sprintf("%-*s", termWidth, "~/work/")), ColorOutput(ColorWhite, "The repositories located under a path (starting with /, ./, ../ or ~/)"))
+		fmt.Printf("  %s %s\n", ColorOutput(ColorCyan, fmt.Sprintf("%-*s", termWidth, "!term")), ColorOutput(ColorWhite, "Exclude the repositories matched by the term"))
+		fmt.Println(ColorOutput(ColorWhite, "Example: gogit run pull 'api-*,@infra,!legacy-*'"))
+		fmt.Println(ColorOutput(ColorWhite, "         gogit run -r 'api-*' -r @infra pull"))
+		return
+	}
+	if found := FindCommand(cmd); found != nil {
+		found.PrintHelp()
+		return
+	}
+	fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error: Unknown command '%s'", cmd)))
+	fmt.Println(ColorOutput(ColorWhite, "Use 'gogit help' to see the list of available commands."))
+}
+
+// Print the predefined and custom commands of gogit do
+func PrintUserCommands() {
+	fmt.Println(ColorOutput(ColorWhite, "Available predefined commands:"))
+	commands, err := LoadUserCommands()
+	if err != nil {
+		fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error loading custom commands: %s", err)))
+		commands = PredefinedUserCommands()
+	}
+	for cmd, command := range commands {
+		if command.IsInteractive() {
+			fmt.Printf("  %s => %s %s\n", ColorOutput(ColorGreen, cmd), ColorOutput(ColorBlue, strings.Join(command.Args, " ")), ColorOutput(ColorYellow, "(interactive)"))
+		} else {
+			fmt.Printf("  %s => %s\n", ColorOutput(ColorGreen, cmd), ColorOutput(ColorBlue, strings.Join(command.Args, " ")))
 		}
 	}
 }
@@ -109,96 +133,323 @@ func PrintReposList(repos []Repo, simpleOutput bool) {
 	os.Exit(0)
 }
 
+// Command: list groups
+// Description: List the groups of the repositories with the number of repositories in each
+// Example: gogit list groups
+func PrintRepoGroups(repos []Repo) {
+	groups, counts := ListRepoGroups(repos)
+	if len(groups) == 0 {
+		fmt.Println(ColorOutput(ColorYellow, "No groups found"))
+		return
+	}
+	groupWidth := 0
+	for _, group := range groups {
+		if len(group) > groupWidth {
+			groupWidth = len(group)
+		}
+	}
+	for _, group := range groups {
+		fmt.Printf("%s %d\n", ColorOutput(ColorGreen, fmt.Sprintf("%-*s", groupWidth+len(GroupPrefix), GroupPrefix+group)), counts[group])
+	}
+}
+
 // Command: clone
 // Description: Check all repositories and clone the ones that are missing
-// Example: gogit clone
-func CloneRepos(repos []Repo) {
+// The missing repositories are cloned in parallel, see ExecuteInRepos
+// The mode, CloneBare or CloneMirror, clones them all as bare repositories; empty
+// to follow repos.json (see Repo.Bare and Repo.Mirror)
+// Example: gogit clone @backend
+func CloneRepos(ctx context.Context, repos []Repo, selector string, mode string, opts ExecOptions) {
 	if len(repos) == 0 {
 		fmt.Println(ColorOutput(ColorYellow, "No repositories found"))
 		os.Exit(0)
 	}
-	for _, repo := range repos {
-		if _, err := os.Stat(repo.Local); os.IsNotExist(err) {
-			fmt.Printf("Cloning %s into %s\n", repo.Remote, repo.Local)
-			err := repo.Clone()
-			if err != nil {
-				fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error cloning %s: %s", repo.Name, err)))
+
+	// Filter repositories if a selector is provided
+	filteredRepos, err := SelectRepos(repos, selector)
+	if err != nil {
+		fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error: %s", err)))
+		os.Exit(1)
+	}
+
+	// The worktrees come last, as they are added once their parent repositories are cloned
+	var missingRepos, missingWorktrees []Repo
+	for _, repo := range filteredRepos {
+		if _, err := os.Stat(repo.Local); !os.IsNotExist(err) {
+			fmt.Println(ColorOutput(ColorYellow, fmt.Sprintf("Skipping %s: repository already exists", repo.Name)))
+		} else if repo.IsWorktree() {
+			missingWorktrees = append(missingWorktrees, repo)
+		} else if repo.Remote == "" {
+			fmt.Println(ColorOutput(ColorYellow, fmt.Sprintf("Skipping %s: repository has no remote to clone from", repo.Name)))
+		} else {
+			// --bare and --mirror apply to all the repositories cloned
+			switch mode {
+			case CloneBare:
+				repo.Bare = true
+			case CloneMirror:
+				repo.Bare, repo.Mirror = true, true
+			}
+			missingRepos = append(missingRepos, repo)
+		}
+	}
+	// Closed when the clone of a repository is done, whether it succeeded or not
+	cloned := make(map[string]chan struct{})
+	for _, repo := range missingRepos {
+		cloned[repo.Name] = make(chan struct{})
+	}
+	// Worktrees of the same repository are added one at a time, as git locks the repository
+	worktreeLocks := make(map[string]*sync.Mutex)
+	for _, repo := range missingWorktrees {
+		if worktreeLocks[repo.WorktreeOf] == nil {
+			worktreeLocks[repo.WorktreeOf] = &sync.Mutex{}
+		}
+	}
+
+	results := ExecuteInRepos(ctx, append(missingRepos, missingWorktrees...), opts, func(repo *Repo) []string {
+		if repo.IsWorktree() {
+			return []string{"worktree", "add", repo.Local}
+		}
+		return repo.CloneArgs()
+	}, func(ctx context.Context, res *RepoResult, stdout, stderr io.Writer) {
+		if !res.Repo.IsWorktree() {
+			res.Attempts, res.Err = res.Repo.Clone(ctx, stdout, stderr, opts.Retry)
+			// The other remotes are added once the repository is cloned
+			if res.Err == nil && len(res.Repo.Remotes) > 0 {
+				res.Err = res.Repo.SyncRemotes(ctx, stdout, stderr, false)
+			}
+			close(cloned[res.Repo.Name])
+			return
+		}
+		if done, ok := cloned[res.Repo.WorktreeOf]; ok {
+			select {
+			case <-done:
+			case <-ctx.Done():
+				res.Err = ctx.Err()
+				return
 			}
+		}
+		lock := worktreeLocks[res.Repo.WorktreeOf]
+		lock.Lock()
+		defer lock.Unlock()
+		res.Attempts, res.Err = 1, res.Repo.AddWorktree(ctx, FindRepo(repos, res.Repo.WorktreeOf), stdout, stderr)
+	}, func(res *RepoResult) {
+		if res.Repo.IsWorktree() {
+			PrintResultBlock(res, fmt.Sprintf("Adding %s as a worktree of %s", res.Repo.Local, res.Repo.WorktreeOf))
 		} else {
-			fmt.Println(ColorOutput(ColorYellow, fmt.Sprintf("Skipping %s: repository already exists", repo.Name)))
+			PrintResultBlock(res, fmt.Sprintf("Cloning %s into %s", res.Repo.Remote, res.Repo.Local))
 		}
+	})
+	ExitBatch(ctx, results, opts)
+}
+
+// Command: status
+// Description: Show a dashboard of the state of the repositories
+// The state of each repository is probed in parallel, see ProbeInRepos
+// Example: gogit status
+func StatusCommand(ctx context.Context, repos []Repo, selector string, opts ExecOptions) {
+	if len(repos) == 0 {
+		fmt.Println(ColorOutput(ColorYellow, "No repositories found"))
+		os.Exit(0)
 	}
-	os.Exit(0)
+
+	// Filter repositories if a selector is provided
+	filteredRepos, err := SelectRepos(repos, selector)
+	if err != nil {
+		fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error: %s", err)))
+		os.Exit(1)
+	}
+
+	// The state of a bare repository cannot be probed, as it has no work tree
+	filteredRepos = SkipBareRepos(filteredRepos, "the status dashboard needs a work tree")
+	if len(filteredRepos) == 0 {
+		os.Exit(0)
+	}
+
+	results := ProbeInRepos(ctx, filteredRepos, opts)
+
+	// Keep the repositories matching the state filter, if one is provided
+	if !opts.Filter.IsEmpty() {
+		var matching []*RepoResult
+		for _, res := range results {
+			if res.Err != nil || (res.State != nil && opts.Filter.Match(res.State)) {
+				matching = append(matching, res)
+			}
+		}
+		if len(matching) == 0 {
+			fmt.Println(ColorOutput(ColorYellow, "No repositories match the filters"))
+			os.Exit(0)
+		}
+		results = matching
+	}
+	PrintStateTable(results)
+
+	missing := 0
+	for _, res := range results {
+		if res.State != nil && res.State.Missing {
+			missing++
+		}
+		if res.Err != nil {
+			fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error reading the state of %s: %s", res.Repo.Name, res.Err)))
+		}
+	}
+	if missing > 0 {
+		fmt.Println(ColorOutput(ColorYellow, fmt.Sprintf("Missing repositories: %d -- Run <gogit clone>", missing)))
+	}
+	os.Exit(ExitStatus(ctx, results))
+}
+
+// Command: remotes sync
+// Description: Make the remotes of the repositories match the ones declared in repos.json
+// The remotes are added, updated and removed in parallel, see Repo.SyncRemotes
+// With dryRun, the differences are only reported
+// Example: gogit remotes sync --dry-run @backend
+func RemotesSyncCommand(ctx context.Context, repos []Repo, selector string, dryRun bool, opts ExecOptions) {
+	if len(repos) == 0 {
+		fmt.Println(ColorOutput(ColorYellow, "No repositories found"))
+		os.Exit(0)
+	}
+
+	// Filter repositories if a selector is provided
+	filteredRepos, err := SelectRepos(repos, selector)
+	if err != nil {
+		fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error: %s", err)))
+		os.Exit(1)
+	}
+
+	// The worktrees share the remotes of their main repository
+	var syncedRepos []Repo
+	for _, repo := range filteredRepos {
+		if repo.IsWorktree() {
+			fmt.Fprintln(os.Stderr, ColorOutput(ColorYellow, fmt.Sprintf("Skipping %s: worktree of %s, which holds its remotes", repo.Name, repo.WorktreeOf)))
+			continue
+		}
+		syncedRepos = append(syncedRepos, repo)
+	}
+
+	results := ExecuteInRepos(ctx, syncedRepos, opts, func(*Repo) []string {
+		return []string{"remote", "-v"}
+	}, func(ctx context.Context, res *RepoResult, stdout, stderr io.Writer) {
+		res.Attempts, res.Err = 1, res.Repo.SyncRemotes(ctx, stdout, stderr, dryRun)
+	}, func(res *RepoResult) {
+		PrintResultBlock(res, fmt.Sprintf("Syncing the remotes of %s", res.Repo.Name))
+	})
+	ExitBatch(ctx, results, opts)
+}
+
+// Command: config show
+// Description: Show the value of a git configuration key in the repositories
+// The key is looked up in the effective configuration of each repository, see Repo.EffectiveConfig
+// Example: gogit config show user.email @work
+func ConfigShowCommand(repos []Repo, key string, selector string) {
+	if _, _, _, err := splitConfigKey(key); err != nil {
+		fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error: %s", err)))
+		os.Exit(1)
+	}
+
+	// Filter repositories if a selector is provided
+	filteredRepos, err := SelectRepos(repos, selector)
+	if err != nil {
+		fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error: %s", err)))
+		os.Exit(1)
+	}
+	if len(filteredRepos) == 0 {
+		fmt.Println(ColorOutput(ColorYellow, "No repositories found"))
+		os.Exit(0)
+	}
+
+	nameWidth := 0
+	for _, repo := range filteredRepos {
+		if len(repo.Name) > nameWidth {
+			nameWidth = len(repo.Name)
+		}
+	}
+	scopeWidth := len(ScopeWorktree)
+
+	status := 0
+	for _, repo := range filteredRepos {
+		name := ColorOutput(ColorCyan, fmt.Sprintf("%-*s", nameWidth, repo.Name))
+		config, err := repo.EffectiveConfig()
+		if err != nil {
+			fmt.Printf("%s  %s\n", name, ColorOutput(ColorRed, fmt.Sprintf("Error: %s", err)))
+			status = 1
+			continue
+		}
+		entries := config.Lookup(key)
+		if len(entries) == 0 {
+			fmt.Printf("%s  %s\n", name, ColorOutput(ColorYellow, "(not set)"))
+			continue
+		}
+		// All the values are shown in the order of precedence: the last one
+		// wins for single-valued keys, all of them apply for multi-valued keys
+		for i, entry := range entries {
+			if i > 0 {
+				name = strings.Repeat(" ", nameWidth)
+			}
+			value := entry.Value
+			if entry.NoValue {
+				value = "(no value)"
+			}
+			fmt.Printf("%s  %s  %s  %s\n", name, ColorOutput(ColorMagenta, fmt.Sprintf("%-*s", scopeWidth, entry.Scope)), ColorOutput(ColorGreen, value), ColorOutput(ColorBlue, entry.Origin))
+		}
+	}
+	os.Exit(status)
 }
 
 // Command: run
 // Description: Execute a git command on all repositories
 // This function runs the git command in parallel for each repository with goroutines
-// Example: gogit do pull
-func ExecGitCommand(repos []Repo, args []string, repoName string) {
+// and prints the output of each repository as one block once its command is done
+// Example: gogit run -r @backend pull
+func ExecGitCommand(ctx context.Context, repos []Repo, args []string, selector string, opts ExecOptions) {
     if len(repos) == 0 {
         fmt.Println(ColorOutput(ColorYellow, "No repositories found"))
         os.Exit(0)
     }
     if len(args) == 0 {
         fmt.Println(ColorOutput(ColorRed, "Error: Missing command to execute"))
-        fmt.Println(ColorOutput(ColorYellow, "Usage: gogit do <command> [args] [repo_name]"))
+        FindCommand("run").PrintUsage()
         os.Exit(1)
     }
 
-    var wg sync.WaitGroup
-    var mu sync.Mutex
-
     argsStr := strings.Join(args, " ")
 
-    // Filter repositories if a specific repository name is provided
-    filteredRepos := repos
-    if repoName != "" {
-        filteredRepos = []Repo{}
-        for _, repo := range repos {
-            if repo.Name == repoName {
-                filteredRepos = append(filteredRepos, repo)
-                break
-            }
-        }
+    // Filter repositories if a selector is provided
+    filteredRepos, err := SelectRepos(repos, selector)
+    if err != nil {
+        fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error: %s", err)))
+        os.Exit(1)
+    }
+
+    // Skip the bare repositories if the command needs a work tree
+    if NeedsWorkTree(args) {
+        filteredRepos = SkipBareRepos(filteredRepos, fmt.Sprintf("'git %s' needs a work tree", GitSubcommand(args)))
         if len(filteredRepos) == 0 {
-            fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error: Repository '%s' not found", repoName)))
-            os.Exit(1)
+            os.Exit(0)
         }
     }
 
-    for _, repo := range filteredRepos {
-        wg.Add(1)
-        go func(repo Repo) {
-            defer wg.Done()
-
-            mu.Lock()
-            fmt.Println(ColorOutput(ColorCyan, "======================================="))
-            fmt.Println(ColorOutput(ColorCyan, fmt.Sprintf("Executing '%s' in %s", argsStr, repo.Local)))
-            fmt.Println(ColorOutput(ColorCyan, "---------------------------------------"))
-            mu.Unlock()
-
-            // Run the Git command
-            err := repo.RunGitCommand(args)
-
-            mu.Lock()
-            if err != nil {
-                fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error executing command in %s: %s", repo.Name, err)))
-            } else {
-                fmt.Println(ColorOutput(ColorGreen, fmt.Sprintf("Successfully executed command in %s", repo.Name)))
-            }
-            fmt.Println(ColorOutput(ColorCyan, "=======================================\n"))
-            mu.Unlock()
-        }(repo)
+    // Filter repositories by their state if a state filter is provided
+    filteredRepos = FilterReposByState(ctx, filteredRepos, opts)
+    if len(filteredRepos) == 0 {
+        fmt.Fprintln(os.Stderr, ColorOutput(ColorYellow, "No repositories match the filters"))
+        os.Exit(0)
     }
 
-    wg.Wait()
-    os.Exit(0)
+    var results []*RepoResult
+    if opts.Interactive || (IsInteractiveCommand(args) && opts.AllowsInteractive()) {
+        results = RunInteractive(ctx, filteredRepos, args, opts, func(repo *Repo) string {
+            return fmt.Sprintf("Executing '%s' in %s", argsStr, repo.Local)
+        })
+    } else {
+        results = RunInRepos(ctx, filteredRepos, args, opts, func(res *RepoResult) {
+            PrintResultBlock(res, fmt.Sprintf("Executing '%s' in %s", argsStr, res.Repo.Local))
+        })
+    }
+
+    ExitBatch(ctx, results, opts)
 }
 
-// Command: do
-// Description: Show the details of a git command
-// Example: gogit show status myrepo
+// Predefined commands of gogit do, each one a list of git arguments
 var predefinedCommands = map[string][]string{
     // History and status
     "h":           {"log", "--oneline", "--decorate", "--graph", "--all"},
@@ -378,8 +629,56 @@ var predefinedCommands = map[string][]string{
     "worktreeprune": {"worktree", "prune"},
 }
 
-func LoadUserCommands() (map[string][]string, error) {
-    customCommands := make(map[string][]string)
+// Struct UserCommand describes a command that can be executed with gogit do
+// In commands.json, a command is either an array of git arguments, or an object
+// that also declares whether the command is interactive (see IsInteractive):
+// {"args": ["commit", "--verbose"], "interactive": true}
+type UserCommand struct {
+    Args        []string `json:"args"`
+    Interactive *bool    `json:"interactive,omitempty"`
+}
+
+// Read a command of commands.json, in either of its forms
+func (c *UserCommand) UnmarshalJSON(data []byte) error {
+    var args []string
+    if err := json.Unmarshal(data, &args); err == nil {
+        c.Args = args
+        c.Interactive = nil
+        return nil
+    }
+
+    type userCommand UserCommand // Without the UnmarshalJSON method
+    var command userCommand
+    if err := json.Unmarshal(data, &command); err != nil {
+        return fmt.Errorf("Expected an array of arguments or an object with \"args\": %s", err)
+    }
+    if len(command.Args) == 0 {
+        return fmt.Errorf("Missing \"args\" in command")
+    }
+    *c = UserCommand(command)
+    return nil
+}
+
+// Check whether the command needs the terminal
+// The interactive field of commands.json takes precedence over IsInteractiveCommand
+func (c UserCommand) IsInteractive() bool {
+    if c.Interactive != nil {
+        return *c.Interactive
+    }
+    return IsInteractiveCommand(c.Args)
+}
+
+// Return the predefined commands as UserCommands
+func PredefinedUserCommands() map[string]UserCommand {
+    commands := make(map[string]UserCommand)
+    for key, value := range predefinedCommands {
+        commands[key] = UserCommand{Args: value}
+    }
+    return commands
+}
+
+func LoadUserCommands() (map[string]UserCommand, error) {
+    customCommands := make(map[string]UserCommand)
 
 
 	commandsFile := filepath.Join(GetUserConfigDir(), "commands.json")
@@ -395,12 +694,8 @@ func LoadUserCommands() (map[string][]string, error) {
         }
     }
 
-    merged := make(map[string][]string)
-
     // Copy predefined commands to merged
-    for key, value := range predefinedCommands {
-        merged[key] = value
-    }
+    merged := PredefinedUserCommands()
 
     // Override or add custom commands
     for key, value := range customCommands {
@@ -410,14 +705,17 @@ func LoadUserCommands() (map[string][]string, error) {
     return merged, nil
 }
 
-func DoCommand(repos []Repo, args []string, repoName string) {
+// Command: do
+// Description: Execute a predefined or custom command (see LoadUserCommands) on the repositories
+// Example: gogit do st -r myrepo
+func DoCommand(ctx context.Context, repos []Repo, args []string, selector string, opts ExecOptions) {
     if len(repos) == 0 {
         fmt.Println(ColorOutput(ColorYellow, "No repositories found"))
         os.Exit(0)
     }
     if len(args) == 0 {
         fmt.Println(ColorOutput(ColorRed, "Error: Missing command to execute"))
-        fmt.Println(ColorOutput(ColorYellow, "Usage: gogit show <command> [repo_name]"))
+        FindCommand("do").PrintUsage()
         os.Exit(1)
     }
 
@@ -425,58 +723,49 @@ func DoCommand(repos []Repo, args []string, repoName string) {
     commands, err := LoadUserCommands()
     if err != nil {
         fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error loading custom commands, falling back to predefined commands: %s", err)))
-		commands = predefinedCommands
+		commands = PredefinedUserCommands()
     }
 
     // Get the command arguments
-    cmdArgs, exists := commands[args[0]]
+    command, exists := commands[args[0]]
+    cmdArgs := command.Args
     if !exists {
         fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error: Unknown command '%s'", args[0])))
         os.Exit(1)
     }
 
-    // Filter repositories if a specific repository name is provided
-    filteredRepos := repos
-    if repoName != "" {
-        filteredRepos = []Repo{}
-        for _, repo := range repos {
-            if repo.Name == repoName {
-                filteredRepos = append(filteredRepos, repo)
-                break
-            }
-        }
+    // Filter repositories if a selector is provided
+    filteredRepos, err := SelectRepos(repos, selector)
+    if err != nil {
+        fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error: %s", err)))
+        os.Exit(1)
+    }
+
+    // Skip the bare repositories if the command needs a work tree
+    if NeedsWorkTree(cmdArgs) {
+        filteredRepos = SkipBareRepos(filteredRepos, fmt.Sprintf("'%s' needs a work tree", args[0]))
         if len(filteredRepos) == 0 {
-            fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error: Repository '%s' not found", repoName)))
-            os.Exit(1)
+            os.Exit(0)
         }
     }
 
-    var wg sync.WaitGroup
-    var mu sync.Mutex
-
-    for _, repo := range filteredRepos {
-        wg.Add(1)
-        go func(repo Repo) {
-            defer wg.Done()
-
-            mu.Lock()
-            fmt.Println(ColorOutput(ColorCyan, "======================================="))
-            fmt.Println(ColorOutput(ColorCyan, fmt.Sprintf("Details for %s", repo.Name)))
-            fmt.Println(ColorOutput(ColorCyan, "---------------------------------------"))
-            mu.Unlock()
-
-            // Run the Git command
-            err := repo.RunGitCommand(cmdArgs)
-
-            mu.Lock()
-            if err != nil {
-                fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error executing command in %s: %s", repo.Name, err)))
-            }
-            fmt.Println(ColorOutput(ColorCyan, "=======================================\n"))
-            mu.Unlock()
-        }(repo)
+    // Filter repositories by their state if a state filter is provided
+    filteredRepos = FilterReposByState(ctx, filteredRepos, opts)
+    if len(filteredRepos) == 0 {
+        fmt.Fprintln(os.Stderr, ColorOutput(ColorYellow, "No repositories match the filters"))
+        os.Exit(0)
+    }
+
+    var results []*RepoResult
+    if opts.Interactive || (command.IsInteractive() && opts.AllowsInteractive()) {
+        results = RunInteractive(ctx, filteredRepos, cmdArgs, opts, func(repo *Repo) string {
+            return fmt.Sprintf("Details for %s", repo.Name)
+        })
+    } else {
+        results = RunInRepos(ctx, filteredRepos, cmdArgs, opts, func(res *RepoResult) {
+            PrintResultBlock(res, fmt.Sprintf("Details for %s", res.Repo.Name))
+        })
     }
 
-    wg.Wait()
-    os.Exit(0)
+    ExitBatch(ctx, results, opts)
 }
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Struct ConfigEntry is a variable of a git configuration file
+// The section and the key are lower case, as they are case-insensitive in git;
+// the subsection is case-sensitive. The entries of a file are kept in order,
+// so that the multi-valued keys (e.g. remote.<name>.fetch) keep all their values.
+type ConfigEntry struct {
+	Section    string
+	Subsection string
+	Key        string
+	Value      string
+	NoValue    bool   // The key has no "=" (e.g. "bare" alone), which means true for a boolean
+	Origin     string // File the entry was read from, which may be an included file
+	Scope      string // Scope of the entry in an effective configuration (see Repo.EffectiveConfig), empty otherwise
+}
+
+// Return the full name of the entry, i.e. section.key or section.subsection.key
+// (or key alone for a key placed before any section)
+func (e ConfigEntry) Name() string {
+	if e.Section == "" {
+		return e.Key
+	}
+	if e.Subsection != "" {
+		return e.Section + "." + e.Subsection + "." + e.Key
+	}
+	return e.Section + "." + e.Key
+}
+
+// Parse a git configuration file, e.g. .git/config
+// The entries are returned in the order of the file
+func parseGitConfig(configFile string) ([]ConfigEntry, error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open %s: %s", configFile, err)
+	}
+	entries, err := parseGitConfigData(data, configFile)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].Origin = configFile
+	}
+	return entries, nil
+}
+
+// Parse the content of a git configuration file
+// The syntax is the one of git-config(1):
+//   - sections are written [section], [section "subsection"] or, in the
+//     deprecated form, [section.subsection]
+//   - the section and key names are case-insensitive, the subsections are not
+//     (except in the deprecated form)
+//   - a key without "=" is a boolean set to true
+//   - values may be quoted, may contain the escapes \", \\, \n, \t and \b,
+//     and may be continued on the next line with a final backslash
+//   - comments start with # or ; and run to the end of the line, outside quotes
+//   - as in git, a key placed before any section has no section
+//
+// The name of the file is only used in the errors
+func parseGitConfigData(data []byte, name string) ([]ConfigEntry, error) {
+	p := &configParser{data: bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), line: 1, name: name}
+	var entries []ConfigEntry
+	var section, subsection string
+
+	for {
+		c, ok := p.next()
+		if !ok {
+			return entries, nil
+		}
+		switch {
+		case c == '\n' || isConfigSpace(c):
+			continue
+		case c == '#' || c == ';':
+			p.skipLine()
+		case c == '[':
+			var err error
+			section, subsection, err = p.parseSectionHeader()
+			if err != nil {
+				return nil, err
+			}
+		case isConfigAlpha(c):
+			entry, err := p.parseEntry(c)
+			if err != nil {
+				return nil, err
+			}
+			entry.Section, entry.Subsection = section, subsection
+			entries = append(entries, entry)
+		default:
+			return nil, p.errorf("unexpected character %q", c)
+		}
+	}
+}
+
+// State of the parsing of a git configuration file
+type configParser struct {
+	data []byte
+	pos  int
+	line int
+	name string
+}
+
+// Return the next character, false at the end of the data
+func (p *configParser) next() (byte, bool) {
+	if p.pos >= len(p.data) {
+		return 0, false
+	}
+	c := p.data[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c, true
+}
+
+// Return the next character without consuming it
+func (p *configParser) peek() (byte, bool) {
+	if p.pos >= len(p.data) {
+		return 0, false
+	}
+	return p.data[p.pos], true
+}
+
+// Skip the characters up to the end of the line, included
+func (p *configParser) skipLine() {
+	for {
+		c, ok := p.next()
+		if !ok || c == '\n' {
+			return
+		}
+	}
+}
+
+// Return an error located at the current line
+func (p *configParser) errorf(format string, args ...interface{}) error {
+	return p.lineErrorf(0, format, args...)
+}
+
+// Return an error located at the line of the last character read, c, which
+// may be the newline that ends it
+func (p *configParser) lineErrorf(c byte, format string, args ...interface{}) error {
+	line := p.line
+	if c == '\n' {
+		line--
+	}
+	return fmt.Errorf("Bad config line %d in %s: %s", line, p.name, fmt.Sprintf(format, args...))
+}
+
+// Parse a section header, after its opening bracket
+func (p *configParser) parseSectionHeader() (string, string, error) {
+	var name strings.Builder
+	for {
+		c, ok := p.next()
+		if !ok || c == '\n' {
+			return "", "", p.lineErrorf(c, "unterminated section header")
+		}
+		switch {
+		case c == ']':
+			if name.Len() == 0 {
+				return "", "", p.errorf("empty section name")
+			}
+			// Deprecated [section.subsection] form, where the subsection is case-insensitive
+			section, subsection, _ := strings.Cut(strings.ToLower(name.String()), ".")
+			return section, subsection, nil
+		case isConfigSpace(c):
+			return p.parseSubsection(strings.ToLower(name.String()))
+		case isConfigAlnum(c) || c == '-' || c == '.':
+			name.WriteByte(c)
+		default:
+			return "", "", p.errorf("invalid character %q in section name", c)
+		}
+	}
+}
+
+// Parse the quoted subsection of a section header, after the section name
+func (p *configParser) parseSubsection(section string) (string, string, error) {
+	c, ok := p.next()
+	for ok && isConfigSpace(c) {
+		c, ok = p.next()
+	}
+	if !ok || c != '"' {
+		return "", "", p.errorf("expected a quoted subsection in section header")
+	}
+	var subsection strings.Builder
+	for {
+		c, ok := p.next()
+		if !ok || c == '\n' {
+			return "", "", p.lineErrorf(c, "unterminated subsection")
+		}
+		if c == '"' {
+			break
+		}
+		if c == '\\' {
+			// Any escaped character stands for itself, e.g. \" and \\
+			c, ok = p.next()
+			if !ok || c == '\n' {
+				return "", "", p.lineErrorf(c, "unterminated subsection")
+			}
+		}
+		subsection.WriteByte(c)
+	}
+	if c, ok := p.next(); !ok || c != ']' {
+		return "", "", p.errorf("expected ']' after subsection")
+	}
+	return section, subsection.String(), nil
+}
+
+// Parse a key and its value, from the first character of the key
+func (p *configParser) parseEntry(first byte) (ConfigEntry, error) {
+	var key strings.Builder
+	key.WriteByte(first)
+	for {
+		c, ok := p.peek()
+		if !ok || !(isConfigAlnum(c) || c == '-') {
+			break
+		}
+		key.WriteByte(c)
+		p.pos++
+	}
+	entry := ConfigEntry{Key: strings.ToLower(key.String())}
+
+	// Spaces may separate the key from "="
+	for {
+		c, ok := p.peek()
+		if !ok || !isConfigSpace(c) {
+			break
+		}
+		p.pos++
+	}
+	c, ok := p.next()
+	switch {
+	case !ok || c == '\n':
+		entry.NoValue = true
+		return entry, nil
+	case c == '#' || c == ';':
+		entry.NoValue = true
+		p.skipLine()
+		return entry, nil
+	case c != '=':
+		return entry, p.errorf("invalid character %q in key '%s'", c, key.String())
+	}
+
+	value, err := p.parseValue()
+	entry.Value = value
+	return entry, err
+}
+
+// Parse a value, after the "=", up to the end of its line
+// Whitespace outside quotes is turned into spaces, and dropped at the start
+// and the end of the value, like git does
+func (p *configParser) parseValue() (string, error) {
+	var value strings.Builder
+	quoted := false
+	spaces := 0
+	for {
+		c, ok := p.next()
+		if !ok || c == '\n' {
+			if quoted {
+				return "", p.lineErrorf(c, "unterminated quoted value")
+			}
+			return value.String(), nil
+		}
+		if !quoted && isConfigSpace(c) {
+			if value.Len() > 0 {
+				spaces++
+			}
+			continue
+		}
+		if !quoted && (c == '#' || c == ';') {
+			p.skipLine()
+			return value.String(), nil
+		}
+		for ; spaces > 0; spaces-- {
+			value.WriteByte(' ')
+		}
+
+		switch c {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			c, ok = p.next()
+			switch {
+			case !ok:
+				return "", p.errorf("unterminated escape at the end of the file")
+			case c == '\n':
+				// Line continuation
+			case c == '\r':
+				// Line continuation with a CRLF line ending
+				if n, ok := p.peek(); ok && n == '\n' {
+					p.next()
+				}
+			case c == '\\' || c == '"':
+				value.WriteByte(c)
+			case c == 'n':
+				value.WriteByte('\n')
+			case c == 't':
+				value.WriteByte('\t')
+			case c == 'b':
+				value.WriteByte('\b')
+			default:
+				return "", p.errorf("invalid escape \\%c", c)
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+}
+
+// Check whether a character is a space for git-config, i.e. not a newline
+func isConfigSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isConfigAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isConfigAlnum(c byte) bool {
+	return isConfigAlpha(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Read a configuration file with git config -f <file> [options] --list
+// Returns the entries as "name" for the keys without value, "name\nvalue" for
+// the others, and whether git could read the file. git runs outside of any
+// repository, so that no includeIf condition holds.
+func gitConfigList(t *testing.T, file string, options ...string) ([]string, bool) {
+	t.Helper()
+	args := append(append([]string{"config", "-f", file}, options...), "--list", "-z")
+	cmd := exec.Command("git", args...)
+	cmd.Dir = t.TempDir()
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_NOSYSTEM=1", "GIT_CONFIG_GLOBAL=/dev/null")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, false
+	}
+	var entries []string
+	for _, entry := range strings.Split(string(out), "\x00") {
+		if entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, true
+}
+
+// Format the entries of parseGitConfigData as gitConfigList does
+func listEntries(entries []ConfigEntry) []string {
+	var list []string
+	for _, entry := range entries {
+		if entry.NoValue {
+			list = append(list, entry.Name())
+		} else {
+			list = append(list, entry.Name()+"\n"+entry.Value)
+		}
+	}
+	return list
+}
+
+func TestParseGitConfigData(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	tests := []struct {
+		name    string
+		data    string
+		want    []string // Expected entries, as listed by gitConfigList
+		wantErr bool
+	}{
+		{
+			name: "simple",
+			data: "[core]\n\tbare = false\n\tfilemode = true\n",
+			want: []string{"core.bare\nfalse", "core.filemode\ntrue"},
+		},
+		{
+			name: "case of the names",
+			data: "[Core]\n\tFileMode = true\n[Remote \"Origin\"]\n\tURL = a\n",
+			want: []string{"core.filemode\ntrue", "remote.Origin.url\na"},
+		},
+		{
+			name: "quoted values with escapes",
+			data: "[alias]\n\tq = \"a \\\"quoted\\\" value\"\n\tesc = tab\\there\\nnew line\\\\ back\\bspace\n\tsp = \"  kept  \"  trimmed  \n",
+			want: []string{"alias.q\na \"quoted\" value", "alias.esc\ntab\there\nnew line\\ back\bspace", "alias.sp\n  kept    trimmed"},
+		},
+		{
+			name: "continuation lines",
+			data: "[alias]\n\tlong = log \\\n\t--oneline \\\n--graph\n\tquoted = \"one \\\ntwo\"\n",
+			want: []string{"alias.long\nlog  --oneline --graph", "alias.quoted\none two"},
+		},
+		{
+			name: "inline comments",
+			data: "# comment\n; comment\n[core] # comment\n\teditor = vim ; comment\n\tpager = less # comment\n\tquoted = \"a ; b # c\" ; comment\n",
+			want: []string{"core.editor\nvim", "core.pager\nless", "core.quoted\na ; b # c"},
+		},
+		{
+			name: "boolean keys without value",
+			data: "[core]\n\tbare\n\tlogallrefupdates\n\tempty =\n",
+			want: []string{"core.bare", "core.logallrefupdates", "core.empty\n"},
+		},
+		{
+			name: "multi-valued keys",
+			data: "[remote \"origin\"]\n\turl = git@example.com:a.git\n\tfetch = +refs/heads/*:refs/remotes/origin/*\n\tfetch = +refs/tags/*:refs/tags/*\n",
+			want: []string{
+				"remote.origin.url\ngit@example.com:a.git",
+				"remote.origin.fetch\n+refs/heads/*:refs/remotes/origin/*",
+				"remote.origin.fetch\n+refs/tags/*:refs/tags/*",
+			},
+		},
+		{
+			name: "deprecated subsection header",
+			data: "[branch.Main]\n\tremote = origin\n[a.B]\n\tc = d\n",
+			want: []string{"branch.main.remote\norigin", "a.b.c\nd"},
+		},
+		{
+			name: "subsection with escapes",
+			data: "[remote \"a\\\"b\\\\c\"]\n\turl = x\n",
+			want: []string{"remote.a\"b\\c.url\nx"},
+		},
+		{
+			name: "CRLF line endings",
+			data: "[core]\r\n\tbare = false\r\n\tlong = a \\\r\nb\r\n[remote \"origin\"]\r\n\turl = x\r\n",
+			want: []string{"core.bare\nfalse", "core.long\na b", "remote.origin.url\nx"},
+		},
+		{
+			name: "several sections on one line",
+			data: "[a] b = c [d]\n[e]f=g\n",
+			want: []string{"a.b\nc [d]", "e.f\ng"},
+		},
+		{
+			name: "key before any section",
+			data: "bare = true\n[core]\n\tbare\n",
+			want: []string{"bare\ntrue", "core.bare"},
+		},
+		{
+			name: "empty file",
+			data: "",
+		},
+		{name: "unterminated section", data: "[core\n\tbare = true\n", wantErr: true},
+		{name: "unterminated subsection", data: "[remote \"origin]\n", wantErr: true},
+		{name: "unterminated quote", data: "[core]\n\teditor = \"vim\n", wantErr: true},
+		{name: "invalid escape", data: "[core]\n\teditor = a\\qb\n", wantErr: true},
+		{name: "invalid key", data: "[core]\n\t1key = true\n", wantErr: true},
+		{name: "invalid section", data: "[co_re]\n\tkey = true\n", wantErr: true},
+	}
+
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-"))
+			if err := os.WriteFile(file, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// The expected results are checked against git first
+			gitEntries, gitOK := gitConfigList(t, file)
+			if gitOK == tt.wantErr {
+				t.Fatalf("git config --list succeeded: %v, want %v", gitOK, !tt.wantErr)
+			}
+			if gitOK && !reflect.DeepEqual(gitEntries, tt.want) {
+				t.Fatalf("git config --list = %q, want %q", gitEntries, tt.want)
+			}
+
+			entries, err := parseGitConfigData([]byte(tt.data), file)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseGitConfigData succeeded with %q, want an error", listEntries(entries))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseGitConfigData: %s", err)
+			}
+			if got := listEntries(entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseGitConfigData = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// A .git/config as found in real repositories: several remotes with
+// multi-valued keys, branch tracking, includes, escaped quotes, comments and
+// continuations
+const realConfigFile = "testdata/git-config"
+
+func TestParseRealGitConfig(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	file, err := filepath.Abs(realConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, ok := gitConfigList(t, file)
+	if !ok {
+		t.Fatalf("git could not read %s", file)
+	}
+
+	entries, err := parseGitConfig(file)
+	if err != nil {
+		t.Fatalf("parseGitConfig: %s", err)
+	}
+	if got := listEntries(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseGitConfig = %q\nwant %q", got, want)
+	}
+
+	config := NewGitConfig(file, entries)
+	if got := config.GetAll("remote.origin.fetch"); len(got) != 2 {
+		t.Errorf("remote.origin.fetch = %q, want 2 values", got)
+	}
+	if got, _ := config.Get("branch.fix \"quoted\" name.merge"); got != "refs/heads/fix-quoted-name" {
+		t.Errorf("merge of the quoted branch = %q", got)
+	}
+	wantRemotes := map[string]RemoteURLs{
+		"origin":   {Fetch: "git@github.com:example/project.git", Push: "git@github.com:example/project.git"},
+		"upstream": {Fetch: "https://github.com/upstream/project.git"},
+		"mirror":   {Fetch: "git@gitlab.com:example/project.git"},
+	}
+	if got := configRemotes(config); !reflect.DeepEqual(got, wantRemotes) {
+		t.Errorf("configRemotes = %v, want %v", got, wantRemotes)
+	}
+}
+
+func TestLoadRealGitConfigIncludes(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	file, err := filepath.Abs(realConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, ok := gitConfigList(t, file, "--includes")
+	if !ok {
+		t.Fatalf("git could not read %s", file)
+	}
+
+	// Without a git directory, as git outside of a repository, only the
+	// unconditional include applies
+	entries, err := loadGitConfig(file, "")
+	if err != nil {
+		t.Fatalf("loadGitConfig: %s", err)
+	}
+	if got := listEntries(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("loadGitConfig = %q\nwant %q", got, want)
+	}
+	config := NewGitConfig(file, entries)
+	if got, _ := config.Get("core.editor"); got != "code --wait" {
+		t.Errorf("core.editor = %q, want the included value", got)
+	}
+	// The include is placed before the [user] section, which takes precedence
+	if got, _ := config.Get("user.email"); got != "jane.doe@example.com" {
+		t.Errorf("user.email = %q, want the value of the including file", got)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Scopes of the git configuration, from the lowest to the highest precedence
+const (
+	ScopeSystem   = "system"   // /etc/gitconfig, or $GIT_CONFIG_SYSTEM
+	ScopeXDG      = "xdg"      // $XDG_CONFIG_HOME/git/config, part of the global scope for git
+	ScopeGlobal   = "global"   // ~/.gitconfig, or $GIT_CONFIG_GLOBAL
+	ScopeLocal    = "local"    // .git/config, in the common directory for linked worktrees
+	ScopeWorktree = "worktree" // config.worktree in the git directory, if extensions.worktreeConfig is set
+)
+
+// Default system configuration file, when GIT_CONFIG_SYSTEM is not set
+const SystemGitConfig = "/etc/gitconfig"
+
+// Struct scopeFile is a configuration file of a scope
+type scopeFile struct {
+	Scope string
+	File  string
+}
+
+// Return the configuration files shared by all the repositories: system, XDG
+// and global, in this order
+// The files are found as git does, honoring the GIT_CONFIG_NOSYSTEM,
+// GIT_CONFIG_SYSTEM, GIT_CONFIG_GLOBAL and XDG_CONFIG_HOME environment variables.
+// The files may not exist.
+func sharedConfigFiles() []scopeFile {
+	var files []scopeFile
+
+	if !isTruthy(os.Getenv("GIT_CONFIG_NOSYSTEM")) {
+		system := os.Getenv("GIT_CONFIG_SYSTEM")
+		if system == "" {
+			system = SystemGitConfig
+		}
+		files = append(files, scopeFile{ScopeSystem, system})
+	}
+
+	if global := os.Getenv("GIT_CONFIG_GLOBAL"); global != "" {
+		return append(files, scopeFile{ScopeGlobal, global})
+	}
+	home, _ := os.UserHomeDir()
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if xdg == "" && home != "" {
+		xdg = filepath.Join(home, ".config")
+	}
+	if xdg != "" {
+		files = append(files, scopeFile{ScopeXDG, filepath.Join(xdg, "git", "config")})
+	}
+	if home != "" {
+		files = append(files, scopeFile{ScopeGlobal, filepath.Join(home, ".gitconfig")})
+	}
+	return files
+}
+
+// Check whether an environment variable is set to a true boolean value, as git reads it
+func isTruthy(value string) bool {
+	switch strings.ToLower(value) {
+	case "", "0", "false", "no", "off":
+		return false
+	}
+	return true
+}
+
+// Return the effective configuration of the repository
+// The system, XDG, global, local and worktree configuration files are merged
+// as git does: the entries are in the order of precedence, so that the getters
+// of GitConfig return the value that git would use. The Scope of each entry is
+// the scope of the file it comes from, or of the file that includes it.
+// Missing files are ignored.
+func (r *Repo) EffectiveConfig() (*GitConfig, error) {
+	if _, err := os.Stat(r.Local); os.IsNotExist(err) {
+		return nil, fmt.Errorf("Repository is missing: %s does not exist", r.Local)
+	}
+	gitDir, commonDir, err := r.ResolveGitDir()
+	if err != nil {
+		return nil, err
+	}
+	localFile := filepath.Join(commonDir, "config")
+
+	var entries []ConfigEntry
+	load := func(scope string, file string) error {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			return nil
+		}
+		scopeEntries, err := loadGitConfig(file, gitDir)
+		if err != nil {
+			return err
+		}
+		for i := range scopeEntries {
+			scopeEntries[i].Scope = scope
+		}
+		entries = append(entries, scopeEntries...)
+		return nil
+	}
+
+	for _, f := range sharedConfigFiles() {
+		if err := load(f.Scope, f.File); err != nil {
+			return nil, err
+		}
+	}
+	localStart := len(entries)
+	if err := load(ScopeLocal, localFile); err != nil {
+		return nil, err
+	}
+	// The worktree scope is only read when the local configuration enables it
+	local := NewGitConfig(localFile, entries[localStart:])
+	if enabled, _ := local.GetBool("extensions.worktreeConfig", false); enabled {
+		if err := load(ScopeWorktree, filepath.Join(gitDir, "config.worktree")); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewGitConfig(localFile, entries), nil
+}
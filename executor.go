@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Order in which the output of the repositories is printed
+const (
+	OrderConfig     = "config"     // Order of the repositories in repos.json
+	OrderCompletion = "completion" // Order in which the commands finish
+)
+
+// Output modes of a batch execution
+const (
+	OutputBuffered = "buffered" // The output of each repository is printed as one block when it is done
+	OutputStream   = "stream"   // The lines of all repositories are printed as they come, prefixed with the repository name
+	OutputJSON     = "json"     // The results of all repositories are printed as a JSON array at the end
+	OutputNDJSON   = "ndjson"   // The result of each repository is printed as a line of JSON when it is done
+	OutputGroup    = "group"    // Each distinct output is printed once at the end, with the repositories that produced it
+)
+
+// Status of a repository after a batch execution
+const (
+	StatusOK          = "ok"              // The command succeeded
+	StatusFailed      = "failed"          // The command failed
+	StatusInterrupted = "interrupted"     // The command was interrupted by a signal or by ExecOptions.FailFast
+	StatusTimedOut    = "timed out"       // The command was interrupted because it took too long, see ExecOptions.Timeout
+	StatusSkipped     = "skipped"         // The command was not started because the execution was cancelled
+	StatusUserSkipped = "skipped by user" // The user chose to skip the repository, see RunInteractive
+)
+
+// Number of repositories processed at the same time if nothing else is configured
+const DefaultJobs = 8
+
+// Environment variable overriding the default number of jobs
+const JobsEnvVar = "GOGIT_JOBS"
+
+// Options of a batch execution over several repositories
+type ExecOptions struct {
+	Order            string
+	Output           string
+	Jobs             int           // Maximum number of repositories processed at the same time
+	FailFast         bool          // Do not start the remaining repositories after the first failure
+	Retry            RetryPolicy   // Retry policy of the network commands
+	Interactive      bool          // Run the command one repository at a time, attached to the terminal, see RunInteractive
+	IgnoreWhitespace bool          // In group mode, compare the outputs regardless of whitespace
+	ChangedOnly      bool          // Do not print the repositories where the command succeeded without output
+	Filter           StateFilter   // Only process the repositories in a given state, see FilterReposByState
+	Timeout          time.Duration // Maximum time given to the command in each repository, 0 for no limit (overridden by Repo.Timeout)
+}
+
+// Check whether the output is meant for programs rather than humans
+// In that case, nothing but the results is printed on stdout
+func (o ExecOptions) IsMachineOutput() bool {
+	return o.Output == OutputJSON || o.Output == OutputNDJSON
+}
+
+// Check whether interactive commands can be run in the output mode
+// They are attached to the terminal, so their output cannot be captured
+func (o ExecOptions) AllowsInteractive() bool {
+	return o.Output == OutputBuffered || o.Output == OutputStream
+}
+
+// Default options of a batch execution
+// The number of jobs is taken, by order of precedence, from the GOGIT_JOBS
+// environment variable, the settings file and the built-in default.
+// The timeout and the retry policy are taken from the settings file.
+func DefaultExecOptions(settings Settings) ExecOptions {
+	opts := ExecOptions{
+		Order:  OrderConfig,
+		Output: OutputBuffered,
+		Jobs:   DefaultJobs,
+		Retry:  DefaultRetryPolicy(),
+	}
+
+	if settings.Jobs > 0 {
+		opts.Jobs = settings.Jobs
+	}
+	if settings.Retries > 0 {
+		opts.Retry.Retries = settings.Retries
+	}
+	if settings.RetryDelay != "" {
+		delay, err := time.ParseDuration(settings.RetryDelay)
+		if err != nil || delay < 0 {
+			fmt.Fprintln(os.Stderr, ColorOutput(ColorYellow, fmt.Sprintf("[Warning]: Ignoring invalid retry delay '%s' in the settings", settings.RetryDelay)))
+		} else {
+			opts.Retry.Delay = delay
+		}
+	}
+	if settings.Timeout != "" {
+		timeout, err := time.ParseDuration(settings.Timeout)
+		if err != nil || timeout < 0 {
+			fmt.Fprintln(os.Stderr, ColorOutput(ColorYellow, fmt.Sprintf("[Warning]: Ignoring invalid timeout '%s' in the settings", settings.Timeout)))
+		} else {
+			opts.Timeout = timeout
+		}
+	}
+
+	if env := os.Getenv(JobsEnvVar); env != "" {
+		jobs, err := strconv.Atoi(env)
+		if err != nil || jobs < 1 {
+			fmt.Fprintln(os.Stderr, ColorOutput(ColorYellow, fmt.Sprintf("[Warning]: Ignoring invalid %s value '%s'", JobsEnvVar, env)))
+		} else {
+			opts.Jobs = jobs
+		}
+	}
+
+	return opts
+}
+
+// Result of a git command executed in a repository
+// The stdout and stderr of the command are captured in their own buffers
+// so that the output of each repository can be printed as one block
+// (in stream mode, they are captured as well as printed)
+type RepoResult struct {
+	Repo     Repo
+	Args     []string
+	Stdout   bytes.Buffer
+	Stderr   bytes.Buffer
+	Status   string
+	ExitCode int
+	Attempts int        // Number of times the command was run, see RetryPolicy
+	State    *RepoState // Live state of the repository, only set by ProbeInRepos
+	Err      error
+	Start    time.Time
+	End      time.Time
+}
+
+// Check whether the command succeeded without printing anything
+func (r *RepoResult) IsSilent() bool {
+	return r.Status == StatusOK && len(bytes.TrimSpace(r.Stdout.Bytes())) == 0 && len(bytes.TrimSpace(r.Stderr.Bytes())) == 0
+}
+
+// Check whether the command was started in the repository, i.e. it was not skipped
+func (r *RepoResult) Started() bool {
+	return r.Status != StatusSkipped && r.Status != StatusUserSkipped
+}
+
+// Return the time taken by the command
+func (r *RepoResult) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+// Execute a git command in all the given repositories
+// The output of each repository is captured, see ExecuteInRepos
+func RunInRepos(ctx context.Context, repos []Repo, args []string, opts ExecOptions, print func(*RepoResult)) []*RepoResult {
+	return ExecuteInRepos(ctx, repos, opts, func(*Repo) []string {
+		return args
+	}, func(ctx context.Context, res *RepoResult, stdout, stderr io.Writer) {
+		res.Attempts, res.Err = res.Repo.RunGitCommand(ctx, res.Args, stdout, stderr, opts.Retry)
+	}, print)
+}
+
+// Execute a task in all the given repositories
+// The tasks run in parallel on a pool of at most opts.Jobs goroutines, the
+// repositories being picked up in the order of the repos slice. The args
+// function gives the git arguments of each repository, stored in its result
+// before the task runs (even if it is skipped). Each task fills
+// the result of its repository and writes the output of its command to the
+// given writers; the status and exit code are derived from the error.
+// Each task receives a context that expires after the timeout of its repository
+// (Repo.Timeout, or opts.Timeout), after which it is reported as timed out.
+// When the context is cancelled (see InterruptContext), or after the first
+// failure with opts.FailFast, no new repository is started: the remaining ones
+// are marked as skipped, and the tasks still running are interrupted through
+// the context they receive.
+// In buffered mode, the print function is called once per repository, never
+// concurrently, in the order requested by opts.Order. In stream mode, the output
+// is printed line by line while the tasks run, followed by a status line per
+// repository, and the print function is not used. Skipped repositories are not printed.
+// With opts.ChangedOnly, the silent repositories (see IsSilent) are not printed.
+// In group mode, the outputs are printed at the end, see PrintGroups.
+// In json and ndjson modes, the results (skipped repositories included) are
+// printed as JSON instead, see ResultRecord.
+// Returns the results in the order of the repos slice.
+func ExecuteInRepos(ctx context.Context, repos []Repo, opts ExecOptions, args func(*Repo) []string, task func(ctx context.Context, res *RepoResult, stdout, stderr io.Writer), print func(*RepoResult)) []*RepoResult {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]*RepoResult, len(repos))
+	done := make(chan int)
+
+	// Shared by the writers of stream mode
+	var streamMu sync.Mutex
+	width := 0
+	for _, repo := range repos {
+		if len(repo.Name) > width {
+			width = len(repo.Name)
+		}
+	}
+	switch opts.Output {
+	case OutputStream:
+		print = func(res *RepoResult) {
+			streamMu.Lock()
+			defer streamMu.Unlock()
+			PrintStreamStatus(res, width)
+		}
+	case OutputNDJSON:
+		print = PrintResultNDJSON
+	case OutputJSON:
+		print = func(*RepoResult) {}
+		defer PrintResultsJSON(results)
+	case OutputGroup:
+		print = func(*RepoResult) {}
+		defer func() {
+			shown := results
+			if opts.ChangedOnly {
+				shown = nil
+				for _, res := range results {
+					if !res.IsSilent() {
+						shown = append(shown, res)
+					}
+				}
+			}
+			PrintGroups(shown, opts.IgnoreWhitespace)
+		}()
+	}
+
+	jobs := opts.Jobs
+	if jobs < 1 || jobs > len(repos) {
+		jobs = len(repos)
+	}
+
+	queue := make(chan int)
+	go func() {
+		for i := range repos {
+			queue <- i
+		}
+		close(queue)
+	}()
+
+	// Process a repository and return its result
+	process := func(i int) *RepoResult {
+		res := &RepoResult{Repo: repos[i]}
+		res.Args = args(&res.Repo)
+		if ctx.Err() != nil {
+			res.Status = StatusSkipped
+			return res
+		}
+
+		repoCtx := ctx
+		timeout, err := res.Repo.GetTimeout()
+		if err != nil {
+			timeout = 0 // Invalid timeouts are rejected when repos.json is loaded
+		}
+		if timeout == 0 {
+			timeout = opts.Timeout
+		}
+		if timeout > 0 {
+			var cancelRepo context.CancelFunc
+			repoCtx, cancelRepo = context.WithTimeout(ctx, timeout)
+			defer cancelRepo()
+		}
+
+		res.Start = time.Now()
+		if opts.Output == OutputStream {
+			prefix := StreamPrefix(res.Repo.Name, width)
+			stdout := NewPrefixWriter(os.Stdout, &streamMu, prefix)
+			stderr := NewPrefixWriter(os.Stderr, &streamMu, prefix)
+			task(repoCtx, res, io.MultiWriter(&res.Stdout, stdout), io.MultiWriter(&res.Stderr, stderr))
+			stdout.Flush()
+			stderr.Flush()
+		} else {
+			task(repoCtx, res, &res.Stdout, &res.Stderr)
+		}
+		res.End = time.Now()
+
+		res.ExitCode = ExitCode(res.Err)
+		switch {
+		case res.Err == nil:
+			res.Status = StatusOK
+		case ctx.Err() != nil:
+			res.Status = StatusInterrupted
+		case repoCtx.Err() != nil:
+			res.Status = StatusTimedOut
+			res.Err = fmt.Errorf("Timed out after %s: %w", timeout, res.Err)
+		default:
+			res.Status = StatusFailed
+		}
+		if res.Status != StatusOK && opts.FailFast {
+			cancel()
+		}
+		return res
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = process(i)
+				done <- i
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Print the results as they come in, or hold them back until all the
+	// repositories before them in the configuration have been printed
+	finished := make([]bool, len(repos))
+	next := 0
+	printResult := func(res *RepoResult) {
+		if opts.IsMachineOutput() {
+			print(res)
+		} else if res.Started() && !(opts.ChangedOnly && res.IsSilent()) {
+			print(res)
+		}
+	}
+	for i := range done {
+		if opts.Order == OrderCompletion || opts.Output == OutputStream {
+			printResult(results[i])
+			continue
+		}
+		finished[i] = true
+		for next < len(repos) && finished[next] {
+			printResult(results[next])
+			next++
+		}
+	}
+
+	return results
+}
+
+// Return the exit code of a command from the error returned by its execution
+// 0 if there is no error, -1 if the command could not be started or did not exit normally
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// Print the captured output of a repository as one contiguous block
+// The title is printed in the banner above the output
+func PrintResultBlock(res *RepoResult, title string) {
+	fmt.Println(ColorOutput(ColorCyan, "======================================="))
+	fmt.Println(ColorOutput(ColorCyan, title))
+	fmt.Println(ColorOutput(ColorCyan, "---------------------------------------"))
+	writeBlock(os.Stdout, res.Stdout.Bytes())
+	writeBlock(os.Stderr, res.Stderr.Bytes())
+	if res.Err != nil {
+		fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error executing command in %s: %s (exit code %d)", res.Repo.Name, res.Err, res.ExitCode)))
+	} else {
+		fmt.Println(ColorOutput(ColorGreen, fmt.Sprintf("Successfully executed command in %s (exit code %d)", res.Repo.Name, res.ExitCode)))
+	}
+	fmt.Println(ColorOutput(ColorCyan, "=======================================\n"))
+}
+
+// Create a context that is cancelled when gogit receives SIGINT (Ctrl-C) or SIGTERM
+// The running git commands are then interrupted, see newGitCommand. A second
+// signal exits immediately, without waiting for git to clean up.
+// The returned function must be called to stop listening for the signals.
+func InterruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Fprintln(os.Stderr, ColorOutput(ColorYellow, fmt.Sprintf("Received %s, interrupting the running commands... (press Ctrl-C again to exit immediately)", sig)))
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+		<-signals
+		os.Exit(130)
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// Return the exit code of gogit after a batch execution
+// 130 if the execution was interrupted by a signal, 0 if the command succeeded
+// in all the repositories (except those the user chose to skip), 1 otherwise
+func ExitStatus(ctx context.Context, results []*RepoResult) int {
+	if ctx.Err() != nil {
+		return 130
+	}
+	for _, res := range results {
+		if res.Status != StatusOK && res.Status != StatusUserSkipped {
+			return 1
+		}
+	}
+	return 0
+}
+
+// End a batch command
+// Print the summary of the execution according to the output mode, then exit
+// with the exit code given by ExitStatus
+// With opts.ChangedOnly, the silent repositories (see IsSilent) are left out of
+// the summary table and only counted.
+func ExitBatch(ctx context.Context, results []*RepoResult, opts ExecOptions) {
+	if !opts.IsMachineOutput() && len(results) > 0 {
+		var shown []*RepoResult
+		silent := 0
+		for _, res := range results {
+			if opts.ChangedOnly && res.IsSilent() {
+				silent++
+			} else {
+				shown = append(shown, res)
+			}
+		}
+		if opts.Output != OutputGroup && len(shown) > 0 {
+			PrintSummaryTable(shown)
+		}
+		if opts.ChangedOnly {
+			fmt.Println(ColorOutput(ColorCyan, fmt.Sprintf("%d repositories with no output", silent)))
+		}
+		PrintTotals(results)
+	}
+	os.Exit(ExitStatus(ctx, results))
+}
+
+// Print a summary table of a batch execution
+// One line per repository with its status, the time taken and the exit code
+func PrintSummaryTable(results []*RepoResult) {
+	nameWidth := len("Repository")
+	for _, res := range results {
+		if len(res.Repo.Name) > nameWidth {
+			nameWidth = len(res.Repo.Name)
+		}
+	}
+	statusWidth := len(StatusUserSkipped)
+
+	fmt.Println(ColorOutput(ColorCyan, "Summary:"))
+	fmt.Println(ColorOutput(ColorCyan, fmt.Sprintf("  %-*s  %-*s  %10s  %9s  %8s", nameWidth, "Repository", statusWidth, "Status", "Duration", "Exit code", "Attempts")))
+	for _, res := range results {
+		color := ColorGreen
+		switch res.Status {
+		case StatusFailed, StatusTimedOut:
+			color = ColorRed
+		case StatusInterrupted, StatusSkipped, StatusUserSkipped:
+			color = ColorYellow
+		}
+		duration, exitCode, attempts := "-", "-", "-"
+		if res.Started() {
+			duration = res.Duration().Round(time.Millisecond).String()
+			exitCode = strconv.Itoa(res.ExitCode)
+			attempts = strconv.Itoa(res.Attempts)
+		}
+		fmt.Printf("  %-*s  %s  %10s  %9s  %8s\n", nameWidth, res.Repo.Name, ColorOutput(color, fmt.Sprintf("%-*s", statusWidth, res.Status)), duration, exitCode, attempts)
+	}
+}
+
+// Print the number of repositories of each status on one line
+func PrintTotals(results []*RepoResult) {
+	counts := make(map[string]int)
+	for _, res := range results {
+		counts[res.Status]++
+	}
+	line := fmt.Sprintf("%d succeeded, %d failed", counts[StatusOK], counts[StatusFailed])
+	if counts[StatusTimedOut] > 0 {
+		line += fmt.Sprintf(", %d timed out", counts[StatusTimedOut])
+	}
+	if counts[StatusInterrupted] > 0 {
+		line += fmt.Sprintf(", %d interrupted", counts[StatusInterrupted])
+	}
+	if counts[StatusSkipped] > 0 {
+		line += fmt.Sprintf(", %d skipped", counts[StatusSkipped])
+	}
+	if counts[StatusUserSkipped] > 0 {
+		line += fmt.Sprintf(", %d skipped by user", counts[StatusUserSkipped])
+	}
+	if counts[StatusOK]+counts[StatusUserSkipped] == len(results) {
+		fmt.Println(ColorOutput(ColorGreen, line))
+	} else {
+		fmt.Println(ColorOutput(ColorRed, line))
+	}
+}
+
+// Print the status line of a repository in stream mode
+func PrintStreamStatus(res *RepoResult, width int) {
+	prefix := StreamPrefix(res.Repo.Name, width)
+	if res.Err != nil {
+		fmt.Println(prefix + ColorOutput(ColorRed, fmt.Sprintf("Error: %s (exit code %d)", res.Err, res.ExitCode)))
+	} else {
+		fmt.Println(prefix + ColorOutput(ColorGreen, fmt.Sprintf("Done (exit code %d)", res.ExitCode)))
+	}
+}
+
+// Write a captured output, making sure it ends with a newline
+func writeBlock(f *os.File, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	f.Write(data)
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		f.Write([]byte("\n"))
+	}
+}
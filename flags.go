@@ -0,0 +1,456 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Struct Flag describes an option of the command line
+type Flag struct {
+	Name  string   // Long name, given as --name
+	Short string   // One-letter name, given as -n, empty if none
+	Value string   // Name of the value shown in the help, empty for a boolean flag
+	Usage []string // Description shown in the help, one element per line
+	Set   func(inv *Invocation, value string) error
+}
+
+// Struct FlagSet is a list of flags shown as one section of the help
+type FlagSet struct {
+	Title string
+	Flags []*Flag
+}
+
+// Struct Command describes a command of gogit
+// The commands are listed by AllCommands
+type Command struct {
+	Name        string
+	Usages      []string   // Arguments shown after the name in the usage lines
+	Summary     string     // Description shown in the list of commands
+	Description []string   // Description shown in the help of the command, one element per line
+	FlagSets    []*FlagSet // Options of the command, in addition to the global options
+	PassThrough bool       // The arguments after the first positional one are passed as-is, options included
+	NoRepos     bool       // The command does not need the repositories
+	MoreHelp    func()     // Print additional help, after the options
+	Run         func(inv *Invocation, repos []Repo)
+}
+
+// Struct Invocation is the parsed command line
+type Invocation struct {
+	Command   *Command
+	Args      []string // Positional arguments of the command
+	Separated bool     // The positional arguments were separated from the options with --
+	Terms     []string // Terms of the selector given with --repo and --group
+	Opts      ExecOptions
+	ReposFile string // Empty for the default repos.json
+	CloneMode string // CloneBare or CloneMirror, set by the options of clone
+	DryRun    bool   // Report the changes without applying them, see RemotesSyncCommand
+	Fix       string // FixJSON or FixConfig, set by the options of check
+	Help      bool
+}
+
+// Options accepted by all the commands, before or after the command name
+var GlobalFlags = &FlagSet{Title: "Global options", Flags: []*Flag{
+	{Name: "repo", Short: "r", Value: "<selector>", Usage: []string{"Select the repositories, see 'gogit help selectors' (can be repeated)"},
+		Set: func(inv *Invocation, value string) error {
+			inv.Terms = append(inv.Terms, value)
+			return nil
+		}},
+	{Name: "group", Value: "<group>", Usage: []string{"Select the repositories of a group, same as --repo @<group> (can be repeated)"},
+		Set: func(inv *Invocation, value string) error {
+			inv.Terms = append(inv.Terms, GroupPrefix+strings.TrimPrefix(value, GroupPrefix))
+			return nil
+		}},
+	{Name: "jobs", Value: "<n>", Usage: []string{fmt.Sprintf("Maximum number of repositories processed at the same time (default %d)", DefaultJobs)},
+		Set: func(inv *Invocation, value string) error {
+			jobs, err := strconv.Atoi(value)
+			if err != nil || jobs < 1 {
+				return fmt.Errorf("Invalid number of jobs '%s'", value)
+			}
+			inv.Opts.Jobs = jobs
+			return nil
+		}},
+	{Name: "output", Value: "<mode>", Usage: []string{
+		"buffered: print the output of each repository as one block (default)",
+		"stream: stream the lines of all repositories, prefixed with their name",
+		"group: print each distinct output once, with the repositories that produced it",
+		"json, ndjson: print the results as a JSON array, or as one line of JSON per repository"},
+		Set: func(inv *Invocation, value string) error {
+			switch value {
+			case OutputBuffered, OutputStream, OutputGroup, OutputJSON, OutputNDJSON:
+				inv.Opts.Output = value
+				return nil
+			}
+			return fmt.Errorf("Invalid output '%s', expected one of: %s", value, strings.Join([]string{OutputBuffered, OutputStream, OutputGroup, OutputJSON, OutputNDJSON}, ", "))
+		}},
+	{Name: "no-color", Usage: []string{"Do not color the output (also disabled by the NO_COLOR environment variable)"},
+		Set: func(inv *Invocation, value string) error {
+			ColorEnabled = false
+			return nil
+		}},
+	{Name: "config", Value: "<file>", Usage: []string{"Read the repositories from the given file instead of repos.json"},
+		Set: func(inv *Invocation, value string) error {
+			inv.ReposFile = value
+			return nil
+		}},
+	{Name: "verbose", Usage: []string{"Print the git commands that are executed on stderr"},
+		Set: func(inv *Invocation, value string) error {
+			Verbose = true
+			return nil
+		}},
+	{Name: "help", Short: "h", Usage: []string{"Print the help of the command"},
+		Set: func(inv *Invocation, value string) error {
+			inv.Help = true
+			return nil
+		}},
+}}
+
+// Options of the batch commands, see ExecOptions
+var ExecFlags = &FlagSet{Title: "Options", Flags: []*Flag{
+	{Name: "order", Value: "config|completion", Usage: []string{"Print the output of the repositories in the order of repos.json (default) or as they finish"},
+		Set: func(inv *Invocation, value string) error {
+			if value != OrderConfig && value != OrderCompletion {
+				return fmt.Errorf("Invalid order '%s', expected '%s' or '%s'", value, OrderConfig, OrderCompletion)
+			}
+			inv.Opts.Order = value
+			return nil
+		}},
+	{Name: "changed-only", Usage: []string{"Only print the repositories where the command failed or printed something"},
+		Set: func(inv *Invocation, value string) error {
+			inv.Opts.ChangedOnly = true
+			return nil
+		}},
+	{Name: "ignore-whitespace", Usage: []string{"In group mode, compare the outputs regardless of whitespace"},
+		Set: func(inv *Invocation, value string) error {
+			inv.Opts.IgnoreWhitespace = true
+			return nil
+		}},
+	{Name: "fail-fast", Usage: []string{"Do not start the remaining repositories after the first failure"},
+		Set: func(inv *Invocation, value string) error {
+			inv.Opts.FailFast = true
+			return nil
+		}},
+	{Name: "timeout", Value: "<duration>", Usage: []string{"Interrupt the command in a repository after the given time, e.g. 30s or 2m"},
+		Set: func(inv *Invocation, value string) error {
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout < 0 {
+				return fmt.Errorf("Invalid timeout '%s', expected a duration such as 30s or 2m", value)
+			}
+			inv.Opts.Timeout = timeout
+			return nil
+		}},
+	{Name: "retries", Value: "<n>", Usage: []string{"Retry network commands (clone, fetch, pull, push, ls-remote) up to n times on transient failures"},
+		Set: func(inv *Invocation, value string) error {
+			retries, err := strconv.Atoi(value)
+			if err != nil || retries < 0 {
+				return fmt.Errorf("Invalid number of retries '%s'", value)
+			}
+			inv.Opts.Retry.Retries = retries
+			return nil
+		}},
+	{Name: "interactive", Usage: []string{"Run the command one repository at a time, attached to the terminal (automatic for interactive commands)"},
+		Set: func(inv *Invocation, value string) error {
+			inv.Opts.Interactive = true
+			return nil
+		}},
+}}
+
+// Options of clone, see CloneRepos
+var CloneFlags = &FlagSet{Title: "Clone options", Flags: []*Flag{
+	{Name: "bare", Usage: []string{"Clone the repositories as bare repositories, without a work tree"},
+		Set: func(inv *Invocation, value string) error {
+			inv.CloneMode = CloneBare
+			return nil
+		}},
+	{Name: "mirror", Usage: []string{"Clone the repositories as bare mirrors of their remote, with all its refs"},
+		Set: func(inv *Invocation, value string) error {
+			inv.CloneMode = CloneMirror
+			return nil
+		}},
+}}
+
+// Options of remotes sync, see RemotesSyncCommand
+var SyncFlags = &FlagSet{Title: "Sync options", Flags: []*Flag{
+	{Name: "dry-run", Usage: []string{"Only report the differences, and fail in the repositories that are not in sync"},
+		Set: func(inv *Invocation, value string) error {
+			inv.DryRun = true
+			return nil
+		}},
+}}
+
+// Options of check, see CheckCommand
+var CheckFlags = &FlagSet{Title: "Options", Flags: []*Flag{
+	{Name: "fix-json", Usage: []string{"Update the remotes in repos.json from the git configuration of the repositories"},
+		Set: func(inv *Invocation, value string) error {
+			return inv.setFix(FixJSON)
+		}},
+	{Name: "fix-config", Usage: []string{"Update the remotes in the git configuration of the repositories from repos.json"},
+		Set: func(inv *Invocation, value string) error {
+			return inv.setFix(FixConfig)
+		}},
+}}
+
+// Set the direction of the fixes of check, which can only be given once
+func (inv *Invocation) setFix(fix string) error {
+	if inv.Fix != "" && inv.Fix != fix {
+		return fmt.Errorf("--fix-json and --fix-config cannot be used together")
+	}
+	inv.Fix = fix
+	return nil
+}
+
+// Options selecting the repositories by their state, see StateFilter
+var FilterFlags = &FlagSet{Title: "Filters", Flags: []*Flag{
+	{Name: "dirty", Usage: []string{"Repositories with changes (staged, unstaged or untracked)"},
+		Set: func(inv *Invocation, value string) error {
+			inv.Opts.Filter.Dirty = true
+			return nil
+		}},
+	{Name: "clean", Usage: []string{"Repositories without changes"},
+		Set: func(inv *Invocation, value string) error {
+			inv.Opts.Filter.Clean = true
+			return nil
+		}},
+	{Name: "ahead", Usage: []string{"Repositories ahead of their upstream"},
+		Set: func(inv *Invocation, value string) error {
+			inv.Opts.Filter.Ahead = true
+			return nil
+		}},
+	{Name: "behind", Usage: []string{"Repositories behind their upstream"},
+		Set: func(inv *Invocation, value string) error {
+			inv.Opts.Filter.Behind = true
+			return nil
+		}},
+	{Name: "diverged", Usage: []string{"Repositories both ahead of and behind their upstream"},
+		Set: func(inv *Invocation, value string) error {
+			inv.Opts.Filter.Diverged = true
+			return nil
+		}},
+	{Name: "on-branch", Value: "<branch>", Usage: []string{"Repositories on the given branch"},
+		Set: func(inv *Invocation, value string) error {
+			inv.Opts.Filter.OnBranch = value
+			return nil
+		}},
+	{Name: "detached", Usage: []string{"Repositories with a detached HEAD"},
+		Set: func(inv *Invocation, value string) error {
+			inv.Opts.Filter.Detached = true
+			return nil
+		}},
+	{Name: "has-stash", Usage: []string{"Repositories with stashes"},
+		Set: func(inv *Invocation, value string) error {
+			inv.Opts.Filter.HasStash = true
+			return nil
+		}},
+	{Name: "missing", Usage: []string{"Repositories missing on disk"},
+		Set: func(inv *Invocation, value string) error {
+			inv.Opts.Filter.Missing = true
+			return nil
+		}},
+	{Name: "no-upstream", Usage: []string{"Repositories whose current branch has no upstream"},
+		Set: func(inv *Invocation, value string) error {
+			inv.Opts.Filter.NoUpstream = true
+			return nil
+		}},
+}}
+
+// Return the command with the given name, nil if there is none
+func FindCommand(name string) *Command {
+	for _, cmd := range AllCommands() {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// Parse the command line, without the program name
+// The global options can be placed before or after the command name, the
+// options of the command after it. Options and positional arguments can be
+// mixed, except for the commands with PassThrough set (run), where everything
+// after the first positional argument is passed as-is. Parsing of options
+// stops after "--".
+// The options default to the settings, see DefaultExecOptions.
+// The invocation is returned along with the error, with the command if it was found.
+func ParseCommandLine(argv []string, settings Settings) (*Invocation, error) {
+	inv := &Invocation{Opts: DefaultExecOptions(settings)}
+	args := argv
+	for len(args) > 0 {
+		arg := args[0]
+		args = args[1:]
+
+		switch {
+		case arg == "--":
+			if inv.Command == nil {
+				return inv, fmt.Errorf("Missing command before '--'")
+			}
+			inv.Separated = true
+			inv.Args = append(inv.Args, args...)
+			args = nil
+
+		case len(arg) > 1 && arg[0] == '-':
+			var err error
+			args, err = inv.parseFlag(arg, args)
+			if err != nil {
+				return inv, err
+			}
+
+		case inv.Command == nil:
+			inv.Command = FindCommand(arg)
+			if inv.Command == nil {
+				return inv, fmt.Errorf("Unknown command '%s'", arg)
+			}
+
+		default:
+			inv.Args = append(inv.Args, arg)
+			if inv.Command.PassThrough {
+				inv.Args = append(inv.Args, args...)
+				args = nil
+			}
+		}
+	}
+
+	if inv.Opts.Interactive && !inv.Opts.AllowsInteractive() {
+		return inv, fmt.Errorf("Option --interactive cannot be used with --output %s", inv.Opts.Output)
+	}
+	return inv, nil
+}
+
+// Parse the option arg, taking its value from the next arguments if needed
+// Returns the remaining arguments
+func (inv *Invocation) parseFlag(arg string, args []string) ([]string, error) {
+	var name, value string
+	var hasValue bool
+	if strings.HasPrefix(arg, "--") {
+		name, value, hasValue = strings.Cut(arg[2:], "=")
+	} else {
+		name = arg[1:2]
+		if len(arg) > 2 {
+			value, hasValue = strings.TrimPrefix(arg[2:], "="), true
+		}
+	}
+
+	flag := inv.lookupFlag(name, len(arg) > 1 && arg[1] != '-')
+	if flag == nil {
+		if inv.Command != nil {
+			return nil, fmt.Errorf("Unknown option %s for gogit %s", strings.SplitN(arg, "=", 2)[0], inv.Command.Name)
+		}
+		return nil, fmt.Errorf("Unknown option %s", strings.SplitN(arg, "=", 2)[0])
+	}
+
+	if flag.Value == "" {
+		if hasValue {
+			return nil, fmt.Errorf("Option --%s does not take a value", flag.Name)
+		}
+		return args, flag.Set(inv, "")
+	}
+	if !hasValue {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("Missing value for option --%s", flag.Name)
+		}
+		value, args = args[0], args[1:]
+	}
+	return args, flag.Set(inv, value)
+}
+
+// Return the flag with the given long or short name among the options
+// accepted at this point: the global options, and those of the command if it is known
+func (inv *Invocation) lookupFlag(name string, short bool) *Flag {
+	for _, set := range inv.flagSets() {
+		for _, flag := range set.Flags {
+			if (short && flag.Short != "" && flag.Short == name) || (!short && flag.Name == name) {
+				return flag
+			}
+		}
+	}
+	return nil
+}
+
+// Return the sets of options accepted at this point of the parsing
+func (inv *Invocation) flagSets() []*FlagSet {
+	if inv.Command == nil {
+		return []*FlagSet{GlobalFlags}
+	}
+	return append(append([]*FlagSet{}, inv.Command.FlagSets...), GlobalFlags)
+}
+
+// Return the selector given with --repo and --group, empty if none
+func (inv *Invocation) Selector() string {
+	return strings.Join(inv.Terms, SelectorSeparator)
+}
+
+// Return the path of the repos.json file, given with --config or the default one
+func (inv *Invocation) ReposPath() string {
+	if inv.ReposFile != "" {
+		return inv.ReposFile
+	}
+	return filepath.Join(GetUserConfigDir(), "repos.json")
+}
+
+// Return the selector of the repositories and the positional arguments without it
+// The selector is the one given with --repo and --group, if any. Otherwise it
+// is the positional argument that follows the first n ones, if there is one.
+// Returns an error if there are more positional arguments than that.
+func (inv *Invocation) SplitSelector(args []string, n int) (string, []string, error) {
+	if len(inv.Terms) > 0 {
+		if len(args) > n {
+			return "", nil, fmt.Errorf("Unexpected argument '%s', the repositories are already selected with --repo or --group", args[n])
+		}
+		return inv.Selector(), args, nil
+	}
+	if len(args) > n+1 {
+		return "", nil, fmt.Errorf("Unexpected argument '%s'", args[n+1])
+	}
+	if len(args) == n+1 {
+		return args[n], args[:n], nil
+	}
+	return "", args, nil
+}
+
+// Print the usage lines of a command
+func (c *Command) PrintUsage() {
+	for i, usage := range c.Usages {
+		prefix := "Usage:"
+		if i > 0 {
+			prefix = "      "
+		}
+		fmt.Println(ColorOutput(ColorYellow, strings.TrimRight(fmt.Sprintf("%s gogit %s %s", prefix, c.Name, usage), " ")))
+	}
+}
+
+// Print the detailed help of a command: usage, description, options and global options
+func (c *Command) PrintHelp() {
+	c.PrintUsage()
+	for _, line := range c.Description {
+		fmt.Println(ColorOutput(ColorWhite, line))
+	}
+	for _, set := range c.FlagSets {
+		set.PrintHelp()
+	}
+	if c.MoreHelp != nil {
+		c.MoreHelp()
+	}
+	GlobalFlags.PrintHelp()
+}
+
+// Print a set of options with aligned columns
+func (s *FlagSet) PrintHelp() {
+	optionWidth := 32
+	fmt.Println(ColorOutput(ColorWhite, s.Title+":"))
+	for _, flag := range s.Flags {
+		label := "--" + flag.Name
+		if flag.Short != "" {
+			label = "-" + flag.Short + ", " + label
+		}
+		if flag.Value != "" {
+			label += " " + flag.Value
+		}
+		for i, line := range flag.Usage {
+			if i > 0 {
+				label = ""
+			}
+			fmt.Printf("  %s %s\n", ColorOutput(ColorCyan, fmt.Sprintf("%-*s", optionWidth, label)), ColorOutput(ColorWhite, line))
+		}
+	}
+}
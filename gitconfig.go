@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Struct GitConfig is the git configuration of a repository
+// It holds the entries of the configuration in order, so that the sections,
+// subsections and multi-valued keys are kept as they are in the file.
+// The getters can be called on a nil GitConfig, which has no entries.
+type GitConfig struct {
+	File    string // Configuration file of the repository, empty if unknown
+	Entries []ConfigEntry
+}
+
+// Create a GitConfig from the entries read from a configuration file, see loadGitConfig
+func NewGitConfig(file string, entries []ConfigEntry) *GitConfig {
+	return &GitConfig{File: file, Entries: entries}
+}
+
+// Split a key such as section.key or section.subsection.key into its parts
+// The subsection may contain dots, e.g. url.https://example.com/.insteadOf.
+// The section and the key name are returned in lower case.
+func splitConfigKey(key string) (string, string, string, error) {
+	first := strings.Index(key, ".")
+	last := strings.LastIndex(key, ".")
+	if first <= 0 || last == len(key)-1 {
+		return "", "", "", fmt.Errorf("Invalid key '%s', expected section.key or section.subsection.key", key)
+	}
+	subsection := ""
+	if last > first {
+		subsection = key[first+1 : last]
+	}
+	return strings.ToLower(key[:first]), subsection, strings.ToLower(key[last+1:]), nil
+}
+
+// Check whether an entry is the variable designated by a key
+func (e ConfigEntry) Matches(key string) bool {
+	section, subsection, name, err := splitConfigKey(key)
+	return err == nil && e.Section == section && e.Subsection == subsection && e.Key == name
+}
+
+// Return the entries of a key, in order
+func (c *GitConfig) Lookup(key string) []ConfigEntry {
+	if c == nil {
+		return nil
+	}
+	var entries []ConfigEntry
+	for _, entry := range c.Entries {
+		if entry.Matches(key) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Get the value of a key
+// As with `git config --get`, the last value wins for the multi-valued keys.
+// The second return value is false if the key is not set.
+func (c *GitConfig) Get(key string) (string, bool) {
+	entries := c.Lookup(key)
+	if len(entries) == 0 {
+		return "", false
+	}
+	return entries[len(entries)-1].Value, true
+}
+
+// Get all the values of a key, in order, e.g. the refspecs of remote.origin.fetch
+func (c *GitConfig) GetAll(key string) []string {
+	var values []string
+	for _, entry := range c.Lookup(key) {
+		values = append(values, entry.Value)
+	}
+	return values
+}
+
+// Get the value of a boolean key, or def if it is not set
+// The values are interpreted as git does: true, yes, on and non-zero numbers
+// are true; false, no, off, 0 and the empty string are false; a key without
+// "=" is true.
+func (c *GitConfig) GetBool(key string, def bool) (bool, error) {
+	entries := c.Lookup(key)
+	if len(entries) == 0 {
+		return def, nil
+	}
+	entry := entries[len(entries)-1]
+	if entry.NoValue {
+		return true, nil
+	}
+	switch strings.ToLower(entry.Value) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off", "":
+		return false, nil
+	}
+	n, err := parseConfigInt(entry.Value)
+	if err != nil {
+		return def, fmt.Errorf("Invalid boolean value '%s' for %s", entry.Value, key)
+	}
+	return n != 0, nil
+}
+
+// Get the value of an integer key, or def if it is not set
+// As in git, the value may have a k, m or g suffix, e.g. 512k
+func (c *GitConfig) GetInt(key string, def int64) (int64, error) {
+	value, ok := c.Get(key)
+	if !ok {
+		return def, nil
+	}
+	n, err := parseConfigInt(value)
+	if err != nil {
+		return def, fmt.Errorf("Invalid integer value '%s' for %s", value, key)
+	}
+	return n, nil
+}
+
+// Parse an integer of a git configuration, with an optional k, m or g suffix
+func parseConfigInt(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	factor := int64(1)
+	if value != "" {
+		switch strings.ToLower(value[len(value)-1:]) {
+		case "k":
+			factor = 1 << 10
+		case "m":
+			factor = 1 << 20
+		case "g":
+			factor = 1 << 30
+		}
+		if factor > 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * factor, nil
+}
+
+// Return the names of the sections, in the order of their first appearance
+func (c *GitConfig) Sections() []string {
+	var sections []string
+	seen := make(map[string]bool)
+	if c == nil {
+		return nil
+	}
+	for _, entry := range c.Entries {
+		if !seen[entry.Section] {
+			seen[entry.Section] = true
+			sections = append(sections, entry.Section)
+		}
+	}
+	return sections
+}
+
+// Return the subsections of a section, in the order of their first appearance
+// e.g. the names of the remotes for the section "remote"
+func (c *GitConfig) Subsections(section string) []string {
+	var subsections []string
+	seen := make(map[string]bool)
+	if c == nil {
+		return nil
+	}
+	section = strings.ToLower(section)
+	for _, entry := range c.Entries {
+		if entry.Section == section && entry.Subsection != "" && !seen[entry.Subsection] {
+			seen[entry.Subsection] = true
+			subsections = append(subsections, entry.Subsection)
+		}
+	}
+	return subsections
+}
+
+// JSON form of a ConfigEntry
+// The value is omitted for the keys without "=", see ConfigEntry.NoValue
+type configEntryJSON struct {
+	Section    string  `json:"section"`
+	Subsection string  `json:"subsection,omitempty"`
+	Key        string  `json:"key"`
+	Value      *string `json:"value,omitempty"`
+	Origin     string  `json:"origin,omitempty"`
+	Scope      string  `json:"scope,omitempty"`
+}
+
+// Write the configuration as a JSON array of entries, in order
+func (c GitConfig) MarshalJSON() ([]byte, error) {
+	records := make([]configEntryJSON, len(c.Entries))
+	for i, entry := range c.Entries {
+		records[i] = configEntryJSON{Section: entry.Section, Subsection: entry.Subsection, Key: entry.Key, Origin: entry.Origin, Scope: entry.Scope}
+		if !entry.NoValue {
+			value := entry.Value
+			records[i].Value = &value
+		}
+	}
+	return json.Marshal(records)
+}
+
+// Read the configuration from a JSON array of entries (see MarshalJSON), or
+// from the object of sections written by the previous versions of gogit:
+// {"core": {"bare": "false"}, "remote": {"origin": {"url": "..."}}}
+func (c *GitConfig) UnmarshalJSON(data []byte) error {
+	var records []configEntryJSON
+	if err := json.Unmarshal(data, &records); err == nil {
+		c.Entries = make([]ConfigEntry, len(records))
+		for i, record := range records {
+			entry := ConfigEntry{Section: strings.ToLower(record.Section), Subsection: record.Subsection, Key: strings.ToLower(record.Key), NoValue: record.Value == nil, Origin: record.Origin, Scope: record.Scope}
+			if record.Value != nil {
+				entry.Value = *record.Value
+			}
+			c.Entries[i] = entry
+		}
+		return nil
+	}
+
+	var sections map[string]map[string]interface{}
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return fmt.Errorf("Expected an array of configuration entries: %s", err)
+	}
+	c.Entries = nil
+	for _, section := range sortedKeys(sections) {
+		for _, name := range sortedKeys(sections[section]) {
+			switch value := sections[section][name].(type) {
+			case string:
+				c.Entries = append(c.Entries, ConfigEntry{Section: strings.ToLower(section), Key: strings.ToLower(name), Value: value})
+			case map[string]interface{}:
+				for _, key := range sortedKeys(value) {
+					if s, ok := value[key].(string); ok {
+						c.Entries = append(c.Entries, ConfigEntry{Section: strings.ToLower(section), Subsection: name, Key: strings.ToLower(key), Value: s})
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Return the keys of a map, sorted
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
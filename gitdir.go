@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Prefix of the line of a .git file that points to the git directory
+const GitDirPrefix = "gitdir:"
+
+// Name of the file of a git directory that points to the common directory, in linked worktrees
+const CommonDirFile = "commondir"
+
+// Find the git directory and the common directory of a repository
+// The git directory is <dir>/.git, or the directory that a .git file points
+// to with a "gitdir: <path>" line, as in linked worktrees and submodules.
+// For a bare repository, the git directory is dir itself.
+// The common directory holds the configuration, the objects and the refs: it
+// is the git directory itself, unless the git directory has a commondir file,
+// as in linked worktrees.
+func ResolveGitDir(dir string) (string, string, error) {
+	dotGit := filepath.Join(dir, ".git")
+	info, err := os.Stat(dotGit)
+	if err != nil {
+		if isGitDir(dir) {
+			return filepath.Clean(dir), filepath.Clean(dir), nil
+		}
+		return "", "", fmt.Errorf("Directory is not a git repository: %s", dir)
+	}
+
+	gitDir := dotGit
+	if !info.IsDir() {
+		data, err := os.ReadFile(dotGit)
+		if err != nil {
+			return "", "", fmt.Errorf("Could not read %s: %s", dotGit, err)
+		}
+		line, _, _ := strings.Cut(string(data), "\n")
+		if !strings.HasPrefix(line, GitDirPrefix) {
+			return "", "", fmt.Errorf("Invalid .git file %s: expected a '%s <path>' line", dotGit, GitDirPrefix)
+		}
+		gitDir = strings.TrimSpace(strings.TrimPrefix(line, GitDirPrefix))
+		if !filepath.IsAbs(gitDir) {
+			gitDir = filepath.Join(dir, gitDir)
+		}
+		gitDir = filepath.Clean(gitDir)
+		if info, err := os.Stat(gitDir); err != nil || !info.IsDir() {
+			return "", "", fmt.Errorf("%s points to %s, which is not a directory", dotGit, gitDir)
+		}
+	}
+
+	commonDir := gitDir
+	if data, err := os.ReadFile(filepath.Join(gitDir, CommonDirFile)); err == nil {
+		commonDir = strings.TrimSpace(string(data))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+		commonDir = filepath.Clean(commonDir)
+	}
+	return gitDir, commonDir, nil
+}
+
+// Find the git directory and the common directory of the repository, see ResolveGitDir
+func (r *Repo) ResolveGitDir() (string, string, error) {
+	return ResolveGitDir(r.Local)
+}
+
+// Check whether the repository is declared as a worktree of another one
+func (r *Repo) IsWorktree() bool {
+	return r.WorktreeOf != ""
+}
+
+// Return the repository with the given name, nil if there is none
+func FindRepo(repos []Repo, name string) *Repo {
+	for i := range repos {
+		if repos[i].Name == name {
+			return &repos[i]
+		}
+	}
+	return nil
+}
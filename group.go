@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Struct ResultGroup gathers the repositories that produced the same output
+type ResultGroup struct {
+	Output   string
+	ExitCode int
+	Status   string
+	Error    string // Error of the execution, e.g. a missing repository or a timeout, empty if none
+	Repos    []string
+}
+
+// Group the results by identical output (stdout followed by stderr), exit code,
+// status and error, so that the repositories that failed for different reasons
+// are not merged into one group
+// With ignoreWhitespace, outputs that only differ by whitespace are considered
+// identical; the output of the first repository of the group is kept.
+// Skipped repositories are left out. The groups are sorted by decreasing size,
+// then by order of first appearance.
+func GroupResults(results []*RepoResult, ignoreWhitespace bool) []*ResultGroup {
+	var groups []*ResultGroup
+	byKey := make(map[string]*ResultGroup)
+	for _, res := range results {
+		if !res.Started() {
+			continue
+		}
+		output := res.Stdout.String() + res.Stderr.String()
+		key := output
+		if ignoreWhitespace {
+			key = strings.Join(strings.Fields(output), " ")
+		}
+		errMsg := ""
+		if res.Err != nil {
+			errMsg = res.Err.Error()
+		}
+		key = fmt.Sprintf("%d\x00%s\x00%s\x00%s", res.ExitCode, res.Status, errMsg, key)
+
+		group, exists := byKey[key]
+		if !exists {
+			group = &ResultGroup{Output: output, ExitCode: res.ExitCode, Status: res.Status, Error: errMsg}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		group.Repos = append(group.Repos, res.Repo.Name)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].Repos) > len(groups[j].Repos)
+	})
+	return groups
+}
+
+// Print each distinct output once, with the repositories that produced it
+func PrintGroups(results []*RepoResult, ignoreWhitespace bool) {
+	for _, group := range GroupResults(results, ignoreWhitespace) {
+		count := fmt.Sprintf("%d repositories", len(group.Repos))
+		if len(group.Repos) == 1 {
+			count = "1 repository"
+		}
+		fmt.Println(ColorOutput(ColorCyan, "======================================="))
+		fmt.Println(ColorOutput(ColorCyan, fmt.Sprintf("%s: %s", count, strings.Join(group.Repos, ", "))))
+		if group.Error != "" {
+			fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("%s (exit code %d): %s", group.Status, group.ExitCode, group.Error)))
+		} else if group.ExitCode != 0 {
+			fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Exit code %d", group.ExitCode)))
+		}
+		fmt.Println(ColorOutput(ColorCyan, "---------------------------------------"))
+		if strings.TrimSpace(group.Output) == "" {
+			fmt.Println(ColorOutput(ColorYellow, "(no output)"))
+		} else {
+			fmt.Print(group.Output)
+			if !strings.HasSuffix(group.Output, "\n") {
+				fmt.Println()
+			}
+		}
+		fmt.Println(ColorOutput(ColorCyan, "=======================================\n"))
+	}
+}
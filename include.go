@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Maximum depth of nested includes, as in git
+const MaxIncludeDepth = 10
+
+// Prefixes of the conditions of the includeIf sections
+const (
+	IncludeIfGitDir     = "gitdir:"
+	IncludeIfGitDirFold = "gitdir/i:"
+	IncludeIfOnBranch   = "onbranch:"
+)
+
+// Read a git configuration file and the files it includes
+// The [include] and [includeIf "<condition>"] sections are resolved as git
+// does: the entries of an included file are inserted after its include.path
+// entry, relative paths are relative to the directory of the including file,
+// and included files that do not exist are ignored. The gitdir:, gitdir/i:
+// and onbranch: conditions are evaluated for the git directory gitDir; the
+// other conditions are never true.
+// The Origin of each entry is the file it was read from.
+func loadGitConfig(configFile string, gitDir string) ([]ConfigEntry, error) {
+	return loadGitConfigFile(configFile, gitDir, nil)
+}
+
+// Read a configuration file with its includes, stack being the files that include it
+func loadGitConfigFile(configFile string, gitDir string, stack []string) ([]ConfigEntry, error) {
+	absFile, err := filepath.Abs(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get absolute path of %s: %s", configFile, err)
+	}
+	for _, file := range stack {
+		if file == absFile {
+			return nil, fmt.Errorf("Include cycle: %s -> %s", strings.Join(stack, " -> "), absFile)
+		}
+	}
+	if len(stack) > MaxIncludeDepth {
+		return nil, fmt.Errorf("Exceeded the maximum include depth (%d) while including %s", MaxIncludeDepth, absFile)
+	}
+	stack = append(stack, absFile)
+
+	entries, err := parseGitConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var resolved []ConfigEntry
+	for _, entry := range entries {
+		resolved = append(resolved, entry)
+		if entry.Key != "path" || entry.NoValue || entry.Value == "" {
+			continue
+		}
+		switch {
+		case entry.Section == "include" && entry.Subsection == "":
+		case entry.Section == "includeif":
+			match, err := matchIncludeCondition(entry.Subsection, absFile, gitDir)
+			if err != nil {
+				return nil, err
+			}
+			if !match {
+				continue
+			}
+		default:
+			continue
+		}
+
+		included, err := resolveIncludePath(entry.Value, absFile)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(included); os.IsNotExist(err) {
+			continue
+		}
+		includedEntries, err := loadGitConfigFile(included, gitDir, stack)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, includedEntries...)
+	}
+	return resolved, nil
+}
+
+// Return the path of an included file, relative to the including file if it is not absolute
+func resolveIncludePath(path string, includingFile string) (string, error) {
+	if strings.HasPrefix(path, "~/") {
+		expanded, err := expandPath(path)
+		if err != nil {
+			return "", err
+		}
+		return expanded, nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(includingFile), path)
+	}
+	return filepath.Clean(path), nil
+}
+
+// Evaluate the condition of an includeIf section
+func matchIncludeCondition(condition string, includingFile string, gitDir string) (bool, error) {
+	switch {
+	case strings.HasPrefix(condition, IncludeIfGitDir), strings.HasPrefix(condition, IncludeIfGitDirFold):
+		if gitDir == "" {
+			return false, nil
+		}
+		foldCase := strings.HasPrefix(condition, IncludeIfGitDirFold)
+		pattern := strings.TrimPrefix(strings.TrimPrefix(condition, IncludeIfGitDir), IncludeIfGitDirFold)
+		switch {
+		case strings.HasPrefix(pattern, "~/"):
+			expanded, err := expandPath(pattern)
+			if err != nil {
+				return false, err
+			}
+			if strings.HasSuffix(pattern, "/") {
+				expanded += "/"
+			}
+			pattern = expanded
+		case strings.HasPrefix(pattern, "./"):
+			pattern = filepath.Dir(includingFile) + pattern[1:]
+		case !filepath.IsAbs(pattern):
+			pattern = "**/" + pattern
+		}
+		if strings.HasSuffix(pattern, "/") {
+			pattern += "**"
+		}
+		re, err := wildmatchRegexp(filepath.ToSlash(pattern), foldCase)
+		if err != nil {
+			return false, fmt.Errorf("Invalid includeIf condition '%s': %s", condition, err)
+		}
+		// The git directory matches either as it is written or once the symbolic links are resolved
+		gitDir, err = filepath.Abs(gitDir)
+		if err != nil {
+			return false, err
+		}
+		paths := []string{gitDir}
+		if real, err := filepath.EvalSymlinks(gitDir); err == nil && real != gitDir {
+			paths = append(paths, real)
+		}
+		for _, path := range paths {
+			if re.MatchString(filepath.ToSlash(path)) {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case strings.HasPrefix(condition, IncludeIfOnBranch):
+		branch := currentBranch(gitDir)
+		if branch == "" {
+			return false, nil
+		}
+		pattern := strings.TrimPrefix(condition, IncludeIfOnBranch)
+		if strings.HasSuffix(pattern, "/") {
+			pattern += "**"
+		}
+		re, err := wildmatchRegexp(pattern, false)
+		if err != nil {
+			return false, fmt.Errorf("Invalid includeIf condition '%s': %s", condition, err)
+		}
+		return re.MatchString(branch), nil
+	}
+	return false, nil
+}
+
+// Return the branch checked out in a git directory, empty if HEAD is detached or cannot be read
+func currentBranch(gitDir string) string {
+	if gitDir == "" {
+		return ""
+	}
+	head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	ref := strings.TrimSpace(strings.TrimPrefix(string(head), "ref:"))
+	if !strings.HasPrefix(ref, "refs/heads/") {
+		return ""
+	}
+	return strings.TrimPrefix(ref, "refs/heads/")
+}
+
+// Convert a wildmatch pattern, as used by the includeIf conditions, to a regular expression
+// * and ? do not match /, ** matches anything including /, and "**/" matches
+// zero or more directories.
+func wildmatchRegexp(pattern string, foldCase bool) (*regexp.Regexp, error) {
+	var re strings.Builder
+	if foldCase {
+		re.WriteString("(?i)")
+	}
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					re.WriteString("(.*/)?")
+				} else {
+					re.WriteString(".*")
+				}
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				re.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			}
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Answers to the prompt between the repositories in interactive mode
+const (
+	AnswerContinue = "continue"
+	AnswerSkip     = "skip"
+	AnswerAbort    = "abort"
+)
+
+// Options of git commands that make them interactive, by git subcommand
+var interactiveOptions = map[string][]string{
+	"add":      {"-i", "--interactive", "-p", "--patch", "-e", "--edit"},
+	"checkout": {"-p", "--patch"},
+	"config":   {"-e", "--edit"},
+	"rebase":   {"-i", "--interactive"},
+	"reset":    {"-p", "--patch"},
+	"restore":  {"-p", "--patch"},
+	"stash":    {"-p", "--patch"},
+}
+
+// Check whether git arguments run a command that needs the terminal
+// e.g. add -p, rebase -i, mergetool, or a commit that opens an editor
+func IsInteractiveCommand(args []string) bool {
+	i := gitSubcommandIndex(args)
+	if i < 0 {
+		return false
+	}
+	subcommand, args := args[i], args[i+1:]
+	switch subcommand {
+	case "mergetool", "difftool":
+		return true
+	case "commit", "tag":
+		// An editor is opened unless the message is given on the command line
+		if subcommand == "tag" && !hasAnyOption(args, "-a", "--annotate", "-s", "--sign") {
+			return false
+		}
+		return !hasAnyOption(args, "-m", "--message", "-F", "--file", "-C", "--reuse-message", "--no-edit")
+	}
+	return hasAnyOption(args, interactiveOptions[subcommand]...)
+}
+
+// Check whether git arguments contain one of the given options
+// Long options may have a value (--message=msg) and short options may be
+// grouped (-am for -a -m)
+func hasAnyOption(args []string, options ...string) bool {
+	for _, arg := range args {
+		for _, option := range options {
+			if strings.HasPrefix(option, "--") {
+				if arg == option || strings.HasPrefix(arg, option+"=") {
+					return true
+				}
+			} else if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg[1:], option[1:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Execute a git command in the given repositories, one at a time
+// The command is attached to the terminal (stdin, stdout and stderr) so that
+// it can prompt the user or open an editor. When there are several
+// repositories, the user is asked before each one whether to continue, skip it
+// or abort. A repository skipped by the user is reported as StatusUserSkipped,
+// which is not a failure; after an abort, the remaining repositories are
+// reported as StatusSkipped. The title function gives the banner printed
+// above each repository.
+// Returns the results in the order of the repos slice; their output is not captured.
+func RunInteractive(ctx context.Context, repos []Repo, args []string, opts ExecOptions, title func(*Repo) string) []*RepoResult {
+	results := make([]*RepoResult, len(repos))
+	stdin := bufio.NewReader(os.Stdin)
+	aborted := false
+
+	for i := range repos {
+		res := &RepoResult{Repo: repos[i], Args: args, Status: StatusSkipped}
+		results[i] = res
+		if aborted || ctx.Err() != nil {
+			continue
+		}
+
+		fmt.Println(ColorOutput(ColorCyan, "======================================="))
+		fmt.Println(ColorOutput(ColorCyan, title(&res.Repo)))
+		fmt.Println(ColorOutput(ColorCyan, "---------------------------------------"))
+
+		if len(repos) > 1 {
+			switch PromptNextRepo(stdin, &res.Repo) {
+			case AnswerSkip:
+				fmt.Println(ColorOutput(ColorYellow, fmt.Sprintf("Skipping %s", res.Repo.Name)))
+				res.Status = StatusUserSkipped
+				continue
+			case AnswerAbort:
+				fmt.Println(ColorOutput(ColorYellow, "Aborting"))
+				aborted = true
+				continue
+			}
+		}
+
+		res.Start = time.Now()
+		res.Attempts = 1
+		res.Err = res.Repo.RunInteractiveGitCommand(args)
+		res.End = time.Now()
+		res.ExitCode = ExitCode(res.Err)
+		switch {
+		case res.Err == nil:
+			res.Status = StatusOK
+			fmt.Println(ColorOutput(ColorGreen, fmt.Sprintf("Successfully executed command in %s (exit code %d)", res.Repo.Name, res.ExitCode)))
+		case ctx.Err() != nil:
+			res.Status = StatusInterrupted
+			fmt.Println(ColorOutput(ColorYellow, fmt.Sprintf("Interrupted command in %s: %s", res.Repo.Name, res.Err)))
+		default:
+			res.Status = StatusFailed
+			fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error executing command in %s: %s (exit code %d)", res.Repo.Name, res.Err, res.ExitCode)))
+			if opts.FailFast {
+				aborted = true
+			}
+		}
+		fmt.Println(ColorOutput(ColorCyan, "=======================================\n"))
+	}
+
+	return results
+}
+
+// Ask the user whether to run the command in the next repository
+// An empty answer means continue; the end of stdin means abort
+func PromptNextRepo(stdin *bufio.Reader, repo *Repo) string {
+	for {
+		fmt.Print(ColorOutput(ColorYellow, fmt.Sprintf("Run in %s? [Y]es, [s]kip, [a]bort: ", repo.Name)))
+		answer, err := stdin.ReadString('\n')
+		if err != nil && answer == "" {
+			fmt.Println()
+			return AnswerAbort
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "", "y", "yes":
+			return AnswerContinue
+		case "s", "skip":
+			return AnswerSkip
+		case "a", "abort", "q", "quit":
+			return AnswerAbort
+		}
+	}
+}
@@ -3,117 +3,343 @@ package main
 import (
 	"fmt"
 	"os"
-	"path/filepath"
+	"strings"
 )
 
 const VERSION = "0.1"
 
 func main() {
-	// Action that must be executed before loading the repositories
-
 	// No argument
 	if len(os.Args) < 2 {
-		fmt.Println(ColorOutput(ColorYellow, fmt.Sprintf("gogit v%s - A simple git repository manager", VERSION)))
-		fmt.Println("Usage: gogit <command> [args]")
-		fmt.Println(fmt.Sprintf("Use '%s' to see the list of available commands.", ColorOutput(ColorGreen, "gogit help")))
+		PrintNoCommand()
 		os.Exit(0)
 	}
 
-	// Command: help
-	if os.Args[1] == "help" {
-		if len(os.Args) > 2 {
-			PrintHelp(os.Args[2])
+	// Load the settings before the command line, whose options default to them
+	settings, err := LoadSettings()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, ColorOutput(ColorYellow, fmt.Sprintf("[Warning]: %s -- Using default settings", err)))
+	}
+	settings.Apply()
+
+	inv, err := ParseCommandLine(os.Args[1:], settings)
+	if err != nil {
+		fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error: %s", err)))
+		if inv.Command != nil {
+			inv.Command.PrintUsage()
 		} else {
+			fmt.Println(fmt.Sprintf("Use '%s' to see the list of available commands.", ColorOutput(ColorGreen, "gogit help")))
+		}
+		os.Exit(1)
+	}
+	if inv.Command == nil {
+		// Only global options
+		if inv.Help {
 			PrintHelp()
+		} else {
+			PrintNoCommand()
 		}
 		os.Exit(0)
 	}
+	if inv.Help {
+		PrintHelp(inv.Command.Name)
+		os.Exit(0)
+	}
 
-	// Command: genrepos
-	// Description: Generate and print a JSON string with the details of all git repositories in a given root folder
-	// Example: gogit genrepos /path/to/root
-	if os.Args[1] == "genrepos" {
-		if len(os.Args) < 3 {
-			fmt.Println(ColorOutput(ColorRed, "Error: Missing root folder argument"))
-			fmt.Println("Usage: gogit genrepos /path/to/root")
-			os.Exit(1)
-		}
-		root := os.Args[2]
-		GenRepos(root)
+	// Actions that must be executed before loading the repositories
+	if inv.Command.NoRepos {
+		inv.Command.Run(inv, nil)
+		os.Exit(0)
 	}
 
 	// Load the repositories from the configuration file
-	reposFile := filepath.Join(GetUserConfigDir(), "repos.json")
+	reposFile := inv.ReposPath()
 	repos, err := LoadReposFromJSON(reposFile)
 	if err != nil {
 		fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error loading repositories: %s", err)))
 		fmt.Println("Please make sure the configuration file exists and is valid.")
 		fmt.Println("The configuration file should be a JSON file that contains an array of repositories.")
-		fmt.Println("It should be located in the OS user's configuration directory, i.e. ~/.config/gogit/repos.json")
+		fmt.Println("It should be located in the OS user's configuration directory, i.e. ~/.config/gogit/repos.json,")
+		fmt.Println("or be given with the --config option.")
 		os.Exit(1)
 	}
+	PrintVerbose("Loaded %d repositories from %s", len(repos), reposFile)
 
-	// Handle commands that require the repositories
-	switch os.Args[1] {
-		// gogit list [full]
-		case "list":
-			simpleOutput := true
-			if len(os.Args) > 2 && os.Args[2] == "full" {
-				simpleOutput = false
-			}
-			PrintReposList(repos, simpleOutput)
-
-		// gogit do <command> [args] [repo_name]
-		case "run":
-			if len(os.Args) < 3 {
-				fmt.Println(ColorOutput(ColorRed, "Error: Missing command to execute"))
-				fmt.Println(ColorOutput(ColorYellow, "Usage: gogit run <command> [args] [repo_name]"))
-				os.Exit(1)
-			}
-			args := os.Args[2:]
-			var repoName string
-			if len(args) > 1 {
-				lastArg := args[len(args)-1]
-				// Check if the last argument is a repository name by seeing if it exists in the repo list
-				for _, repo := range repos {
-					if repo.Name == lastArg {
-						repoName = lastArg
-						args = args[:len(args)-1]
-						break
+	inv.Command.Run(inv, repos)
+}
+
+// Print the message shown when gogit is run without a command
+func PrintNoCommand() {
+	fmt.Println(ColorOutput(ColorYellow, fmt.Sprintf("gogit v%s - A simple git repository manager", VERSION)))
+	fmt.Println("Usage: gogit [global options] <command> [args]")
+	fmt.Println(fmt.Sprintf("Use '%s' to see the list of available commands.", ColorOutput(ColorGreen, "gogit help")))
+}
+
+// Print an error with the usage of the command, and exit
+func exitWithUsage(inv *Invocation, err error) {
+	fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error: %s", err)))
+	inv.Command.PrintUsage()
+	os.Exit(1)
+}
+
+// Return the commands of gogit, in the order of the help
+func AllCommands() []*Command {
+	return []*Command{
+		// gogit list [full] [selector]
+		// gogit list groups
+		{
+			Name:    "list",
+			Usages:  []string{"[full] [selector]", "groups"},
+			Summary: "List the repositories in a simple and compact format, in a detailed format with 'full', or their groups with 'groups'",
+			Description: []string{
+				"List the repositories in a simple and compact format. Use 'full' to list in a detailed format.",
+				"Use 'groups' to list the groups of repositories with the number of repositories in each.",
+			},
+			Run: func(inv *Invocation, repos []Repo) {
+				args := inv.Args
+				if len(args) > 0 && args[0] == "groups" {
+					if len(args) > 1 {
+						exitWithUsage(inv, fmt.Errorf("Unexpected argument '%s'", args[1]))
+					}
+					selected, err := SelectRepos(repos, inv.Selector())
+					if err != nil {
+						exitWithUsage(inv, err)
 					}
+					PrintRepoGroups(selected)
+					os.Exit(0)
+				}
+				simpleOutput := true
+				if len(args) > 0 && args[0] == "full" {
+					simpleOutput = false
+					args = args[1:]
+				}
+				selector, _, err := inv.SplitSelector(args, 0)
+				if err != nil {
+					exitWithUsage(inv, err)
+				}
+				selected, err := SelectRepos(repos, selector)
+				if err != nil {
+					exitWithUsage(inv, err)
 				}
-			}
-			ExecGitCommand(repos, args, repoName)
-
-		// gogit show <command> [repo_name]
-		case "do":
-			if len(os.Args) < 3 {
-				fmt.Println(ColorOutput(ColorRed, "Error: Missing command to execute"))
-				fmt.Println(ColorOutput(ColorYellow, "Usage: gogit do <command> [repo_name]"))
-				os.Exit(1)
-			}
-			args := os.Args[2:]
-			var repoName string
-			if len(args) > 1 {
-				lastArg := args[len(args)-1]
-				// Check if the last argument is a repository name by seeing if it exists in the repo list
-				for _, repo := range repos {
-					if repo.Name == lastArg {
-						repoName = lastArg
+				PrintReposList(selected, simpleOutput)
+			},
+		},
+
+		// gogit status [options] [selector]
+		{
+			Name:    "status",
+			Usages:  []string{"[options] [selector]"},
+			Summary: "Show the branch, upstream, changes, stashes and operation in progress of the repositories",
+			Description: []string{
+				"Show the state of a repository, or of all repositories if no repository is provided:",
+				"current branch, upstream, commits ahead/behind, staged/unstaged/untracked changes, stashes,",
+				"operation in progress (rebase, merge, cherry-pick, bisect...) and age of the last commit.",
+			},
+			FlagSets: []*FlagSet{ExecFlags, FilterFlags},
+			Run: func(inv *Invocation, repos []Repo) {
+				selector, _, err := inv.SplitSelector(inv.Args, 0)
+				if err != nil {
+					exitWithUsage(inv, err)
+				}
+				ctx, stop := InterruptContext()
+				defer stop()
+				StatusCommand(ctx, repos, selector, inv.Opts)
+			},
+		},
+
+		// gogit run [options] [--] <command> [args] [selector]
+		{
+			Name:    "run",
+			Usages:  []string{"[options] [--] <command> [args] [selector]"},
+			Summary: "Execute a git command on a repository or on all repositories if no repository is provided",
+			Description: []string{
+				"Execute a git command on a repository or on all repositories if no repository is provided.",
+				"The options of gogit must be placed before the git command; the arguments after it are passed to git.",
+				"Without --repo or --group, the last argument is taken as a selector if it is the name of a repository or a @group,",
+				"with a warning: this guess is deprecated and will be removed.",
+				"To pass it to git instead, select the repositories with --repo, or put the git command after --,",
+				"e.g. gogit run -r @backend checkout api, or gogit run -- checkout api.",
+			},
+			FlagSets:    []*FlagSet{ExecFlags, FilterFlags},
+			PassThrough: true,
+			Run: func(inv *Invocation, repos []Repo) {
+				args := inv.Args
+				if len(args) == 0 {
+					exitWithUsage(inv, fmt.Errorf("Missing command to execute"))
+				}
+				selector := inv.Selector()
+				if len(inv.Terms) == 0 && !inv.Separated && len(args) > 1 {
+					lastArg := args[len(args)-1]
+					// Check if the last argument is a selector: the name of a repository or a group of the list
+					// The guess is deprecated, so it is always reported
+					if IsRepoSelector(repos, lastArg) {
+						selector = lastArg
 						args = args[:len(args)-1]
-						break
+						fmt.Fprintln(os.Stderr, ColorOutput(ColorYellow, fmt.Sprintf("[Warning]: Taking '%s' as the selector, not as an argument of git. This guess is deprecated: use 'gogit run -r %s %s', or 'gogit run -- %s' to pass it to git", lastArg, lastArg, strings.Join(args, " "), strings.Join(inv.Args, " "))))
 					}
 				}
-			}
-			DoCommand(repos, args, repoName)
+				ctx, stop := InterruptContext()
+				defer stop()
+				ExecGitCommand(ctx, repos, args, selector, inv.Opts)
+			},
+		},
 
-		// gogit clone
-		case "clone":
-			CloneRepos(repos)
+		// gogit do [options] <command> [selector]
+		{
+			Name:    "do",
+			Usages:  []string{"[options] <command> [selector]"},
+			Summary: "Execute a predefined command on a repository or on all repositories if no repository is provided. To show all available commands, use 'gogit help do'",
+			Description: []string{
+				"Execute a predefined command on a repository or on all repositories if no repository is provided.",
+			},
+			FlagSets: []*FlagSet{ExecFlags, FilterFlags},
+			MoreHelp: PrintUserCommands,
+			Run: func(inv *Invocation, repos []Repo) {
+				selector, args, err := inv.SplitSelector(inv.Args, 1)
+				if err != nil {
+					exitWithUsage(inv, err)
+				}
+				if len(args) == 0 {
+					exitWithUsage(inv, fmt.Errorf("Missing command to execute"))
+				}
+				ctx, stop := InterruptContext()
+				defer stop()
+				DoCommand(ctx, repos, args, selector, inv.Opts)
+			},
+		},
 
-		default:
-			fmt.Println(ColorOutput(ColorRed, fmt.Sprintf("Error: Unknown command '%s'", os.Args[1])))
-			fmt.Println(fmt.Sprintf("Use '%s' to see the list of available commands.", ColorOutput(ColorGreen, "gogit help")))
-	}
+		// gogit config show <key> [selector]
+		{
+			Name:    "config",
+			Usages:  []string{"show <key> [selector]"},
+			Summary: "Show the value of a git configuration key in the repositories, with its scope and file",
+			Description: []string{
+				"Show the value of a git configuration key, e.g. user.email or url.<base>.insteadOf, in the repositories.",
+				"The system, xdg, global, local and worktree scopes are merged as git does. All the values of the key",
+				"are shown with the scope and the file they come from, by order of precedence: for the keys that have",
+				"a single value, the last one is the one git uses.",
+			},
+			Run: func(inv *Invocation, repos []Repo) {
+				args := inv.Args
+				if len(args) == 0 {
+					exitWithUsage(inv, fmt.Errorf("Missing subcommand"))
+				}
+				if args[0] != "show" {
+					exitWithUsage(inv, fmt.Errorf("Unknown subcommand '%s'", args[0]))
+				}
+				selector, args, err := inv.SplitSelector(args[1:], 1)
+				if err != nil {
+					exitWithUsage(inv, err)
+				}
+				if len(args) == 0 {
+					exitWithUsage(inv, fmt.Errorf("Missing configuration key"))
+				}
+				ConfigShowCommand(repos, args[0], selector)
+			},
+		},
 
+		// gogit remotes sync [options] [selector]
+		{
+			Name:    "remotes",
+			Usages:  []string{"sync [options] [selector]"},
+			Summary: "Add, update and remove the remotes of the repositories to match repos.json",
+			Description: []string{
+				"Add, update and remove the remotes of the repositories to match the \"remote\" and \"remotes\" fields of repos.json,",
+				"and print the differences. The remotes that are not declared are only removed from the repositories that",
+				"declare their remotes with \"remotes\". Use --dry-run to only report the differences.",
+			},
+			FlagSets: []*FlagSet{ExecFlags, SyncFlags},
+			Run: func(inv *Invocation, repos []Repo) {
+				args := inv.Args
+				if len(args) == 0 {
+					exitWithUsage(inv, fmt.Errorf("Missing subcommand"))
+				}
+				if args[0] != "sync" {
+					exitWithUsage(inv, fmt.Errorf("Unknown subcommand '%s'", args[0]))
+				}
+				selector, _, err := inv.SplitSelector(args[1:], 0)
+				if err != nil {
+					exitWithUsage(inv, err)
+				}
+				ctx, stop := InterruptContext()
+				defer stop()
+				RemotesSyncCommand(ctx, repos, selector, inv.DryRun, inv.Opts)
+			},
+		},
+
+		// gogit check [options] [selector]
+		{
+			Name:    "check",
+			Usages:  []string{"[options] [selector]"},
+			Summary: "Check that repos.json matches the repositories on disk",
+			Description: []string{
+				"Check that repos.json matches the repositories on disk, and report the differences: missing path,",
+				"path that is not a git repository, remotes that differ from the git configuration, worktree of another",
+				"repository, and entries with the same name or the same path (among all the entries). The differences of",
+				"the remotes can be fixed in either direction with --fix-json or --fix-config.",
+			},
+			FlagSets: []*FlagSet{CheckFlags},
+			Run: func(inv *Invocation, repos []Repo) {
+				selector, _, err := inv.SplitSelector(inv.Args, 0)
+				if err != nil {
+					exitWithUsage(inv, err)
+				}
+				ctx, stop := InterruptContext()
+				defer stop()
+				CheckCommand(ctx, repos, inv.ReposPath(), selector, inv.Fix)
+			},
+		},
+
+		// gogit genrepos <root>
+		{
+			Name:        "genrepos",
+			Usages:      []string{"<root>"},
+			Summary:     "Generate and print a JSON string with the details of all git repositories in a given root folder",
+			Description: []string{"Generate and print a JSON string with the details of all git repositories in a given root folder."},
+			NoRepos:     true,
+			Run: func(inv *Invocation, repos []Repo) {
+				if len(inv.Args) == 0 {
+					exitWithUsage(inv, fmt.Errorf("Missing root folder argument"))
+				}
+				if len(inv.Args) > 1 {
+					exitWithUsage(inv, fmt.Errorf("Unexpected argument '%s'", inv.Args[1]))
+				}
+				GenRepos(inv.Args[0])
+			},
+		},
+
+		// gogit clone [options] [selector]
+		{
+			Name:    "clone",
+			Usages:  []string{"[options] [selector]"},
+			Summary: "Check all repositories and clone the ones that are missing",
+			Description: []string{
+				"Check all repositories and clone the ones that are missing.",
+				"The repositories declared with \"bare\" or \"mirror\" in repos.json are cloned with --bare or --mirror,",
+				"and the worktrees declared with \"worktree_of\" are added to their main repository.",
+			},
+			FlagSets: []*FlagSet{ExecFlags, CloneFlags},
+			Run: func(inv *Invocation, repos []Repo) {
+				selector, _, err := inv.SplitSelector(inv.Args, 0)
+				if err != nil {
+					exitWithUsage(inv, err)
+				}
+				ctx, stop := InterruptContext()
+				defer stop()
+				CloneRepos(ctx, repos, selector, inv.CloneMode, inv.Opts)
+			},
+		},
+
+		// gogit help [command]
+		{
+			Name:        "help",
+			Usages:      []string{"[command]"},
+			Summary:     "Print this help message or detailed help for a specific command",
+			Description: []string{"Print this help message or detailed help for a specific command."},
+			NoRepos:     true,
+			Run: func(inv *Invocation, repos []Repo) {
+				PrintHelp(inv.Args...)
+			},
+		},
+	}
 }
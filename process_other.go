@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import (
+	"os/exec"
+)
+
+// Process groups are not supported on this platform, the command is left as is
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// Interrupt a command
+// Signals other than kill are not supported on this platform, so the process is killed
+func interruptProcess(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
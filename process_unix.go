@@ -0,0 +1,20 @@
+//go:build unix
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Start the command in its own process group
+// The terminal then no longer sends Ctrl-C to git directly: gogit forwards the
+// signal itself, to the git process and all its children (ssh, hooks...)
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// Send SIGINT to the process group of a command started with setProcessGroup
+func interruptProcess(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
+}
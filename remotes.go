@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// Struct RemoteURLs is a remote declared in repos.json, see Repo.Remotes
+// It is written {"fetch": "<url>", "push": "<url>"}, or "<url>" alone when
+// the remote has no push URL.
+type RemoteURLs struct {
+	Fetch string `json:"fetch"`
+	Push  string `json:"push,omitempty"` // Empty if git pushes to the fetch URL
+}
+
+// Write a remote as its fetch URL if it has no push URL, as an object otherwise
+func (u RemoteURLs) MarshalJSON() ([]byte, error) {
+	if u.Push == "" {
+		return json.Marshal(u.Fetch)
+	}
+	type remoteURLs RemoteURLs
+	return json.Marshal(remoteURLs(u))
+}
+
+// Read a remote from a JSON object, or from a string that is its fetch URL
+func (u *RemoteURLs) UnmarshalJSON(data []byte) error {
+	var url string
+	if err := json.Unmarshal(data, &url); err == nil {
+		*u = RemoteURLs{Fetch: url}
+		return nil
+	}
+	type remoteURLs RemoteURLs
+	var urls remoteURLs
+	if err := json.Unmarshal(data, &urls); err != nil {
+		return fmt.Errorf("Expected a URL or an object with fetch and push URLs: %s", err)
+	}
+	*u = RemoteURLs(urls)
+	return nil
+}
+
+// Actions of a RemoteChange
+const (
+	RemoteAdd     = "add"      // The remote is declared but missing
+	RemoteSetURL  = "set-url"  // The fetch URL of the remote differs
+	RemoteSetPush = "set-push" // The push URL of the remote differs
+	RemoteRemove  = "remove"   // The remote is not declared
+)
+
+// Struct RemoteChange is a difference between the declared remotes of a
+// repository and the remotes of its git configuration
+type RemoteChange struct {
+	Remote string
+	Action string
+	Old    string // URL in the git configuration, empty for RemoteAdd
+	New    string // Declared URL, empty for RemoteRemove
+}
+
+// Describe the change on one line, e.g. "~ origin: url a -> b"
+func (c RemoteChange) String() string {
+	switch c.Action {
+	case RemoteAdd:
+		return fmt.Sprintf("+ %s: %s", c.Remote, c.New)
+	case RemoteRemove:
+		return fmt.Sprintf("- %s: %s", c.Remote, c.Old)
+	case RemoteSetPush:
+		return fmt.Sprintf("~ %s: push URL %s -> %s", c.Remote, orNone(c.Old), orNone(c.New))
+	}
+	return fmt.Sprintf("~ %s: URL %s -> %s", c.Remote, orNone(c.Old), orNone(c.New))
+}
+
+// Return a URL, or "(none)" if it is empty
+func orNone(url string) string {
+	if url == "" {
+		return "(none)"
+	}
+	return url
+}
+
+// Return the remotes declared for the repository in repos.json
+// They are the remotes of Repo.Remotes and the primary remote, whose URL is
+// Repo.Remote (see PrimaryRemote).
+func (r *Repo) DeclaredRemotes() map[string]RemoteURLs {
+	remotes := make(map[string]RemoteURLs)
+	for name, urls := range r.Remotes {
+		remotes[name] = urls
+	}
+	if _, ok := remotes[r.PrimaryRemote()]; !ok && r.Remote != "" {
+		remotes[r.PrimaryRemote()] = RemoteURLs{Fetch: r.Remote}
+	}
+	return remotes
+}
+
+// Check the remotes declared for the repository, and complete Repo.Remote
+// with the URL of the primary remote if it is only declared in Repo.Remotes
+func (r *Repo) checkRemotes() error {
+	for name, urls := range r.Remotes {
+		if name == "" || urls.Fetch == "" {
+			return fmt.Errorf("Remote '%s' of repository %s has no fetch URL", name, r.Name)
+		}
+	}
+	primary, ok := r.Remotes[r.PrimaryRemote()]
+	switch {
+	case !ok:
+	case r.Remote == "":
+		r.Remote = primary.Fetch
+	case r.Remote != primary.Fetch:
+		return fmt.Errorf("Repository %s has two URLs for remote %s: %s and %s", r.Name, r.PrimaryRemote(), r.Remote, primary.Fetch)
+	}
+	return nil
+}
+
+// Return the fetch URL of a remote in a git configuration
+// A remote may have several url entries: git fetches from the first one (and
+// pushes to all of them), so the first one is the URL of the remote, unlike
+// GitConfig.Get which returns the last value.
+func remoteURL(config *GitConfig, name string) (string, bool) {
+	urls := config.GetAll("remote." + name + ".url")
+	if len(urls) == 0 {
+		return "", false
+	}
+	return urls[0], true
+}
+
+// Return the remotes of a git configuration
+// The fetch URL of a remote is its first url (see remoteURL), and its push
+// URL is its first pushurl.
+func configRemotes(config *GitConfig) map[string]RemoteURLs {
+	remotes := make(map[string]RemoteURLs)
+	for _, name := range config.Subsections("remote") {
+		url, ok := remoteURL(config, name)
+		if !ok {
+			continue
+		}
+		remote := RemoteURLs{Fetch: url}
+		if push := config.GetAll("remote." + name + ".pushurl"); len(push) > 0 {
+			remote.Push = push[0]
+		}
+		remotes[name] = remote
+	}
+	return remotes
+}
+
+// Compare the declared remotes of the repository with the remotes of its git configuration
+// The configuration is read again, as it may have changed since gogit started.
+// The remotes that are not declared are only removed when the repository
+// declares its remotes with Repo.Remotes; otherwise, only the primary remote
+// is managed by gogit.
+// The changes are sorted by remote name.
+func (r *Repo) DiffRemotes() ([]RemoteChange, error) {
+	if err := r.LoadConfig(); err != nil {
+		return nil, err
+	}
+	declared := r.DeclaredRemotes()
+	actual := configRemotes(r.Config)
+
+	var changes []RemoteChange
+	for _, name := range sortedKeys(declared) {
+		want := declared[name]
+		have, ok := actual[name]
+		if !ok {
+			changes = append(changes, RemoteChange{Remote: name, Action: RemoteAdd, New: want.Fetch})
+			if want.Push != "" {
+				changes = append(changes, RemoteChange{Remote: name, Action: RemoteSetPush, New: want.Push})
+			}
+			continue
+		}
+		if have.Fetch != want.Fetch {
+			changes = append(changes, RemoteChange{Remote: name, Action: RemoteSetURL, Old: have.Fetch, New: want.Fetch})
+		}
+		if have.Push != want.Push {
+			changes = append(changes, RemoteChange{Remote: name, Action: RemoteSetPush, Old: have.Push, New: want.Push})
+		}
+	}
+	if r.Remotes != nil {
+		for _, name := range sortedKeys(actual) {
+			if _, ok := declared[name]; !ok {
+				changes = append(changes, RemoteChange{Remote: name, Action: RemoteRemove, Old: actual[name].Fetch})
+			}
+		}
+	}
+	return changes, nil
+}
+
+// Return the git arguments that apply a change to the remotes of a repository
+// Only the first url (or pushurl) of the remote is replaced, as git set-url
+// does with the old URL, so that the other URLs of a remote that has several
+// are kept.
+func (c RemoteChange) GitArgs() []string {
+	switch c.Action {
+	case RemoteAdd:
+		return []string{"remote", "add", c.Remote, c.New}
+	case RemoteSetURL:
+		return []string{"remote", "set-url", c.Remote, c.New, "^" + regexp.QuoteMeta(c.Old) + "$"}
+	case RemoteSetPush:
+		switch {
+		case c.New == "":
+			return []string{"config", "--unset-all", "remote." + c.Remote + ".pushurl"}
+		case c.Old == "":
+			return []string{"remote", "set-url", "--push", c.Remote, c.New}
+		}
+		return []string{"remote", "set-url", "--push", c.Remote, c.New, "^" + regexp.QuoteMeta(c.Old) + "$"}
+	case RemoteRemove:
+		return []string{"remote", "remove", c.Remote}
+	}
+	return nil
+}
+
+// Make the remotes of the repository match the declared ones, see DiffRemotes
+// Each change is written to stdout; with dryRun, the changes are only
+// reported, and an error is returned if there are any.
+func (r *Repo) SyncRemotes(ctx context.Context, stdout, stderr io.Writer, dryRun bool) error {
+	changes, err := r.DiffRemotes()
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		fmt.Fprintln(stdout, change)
+		if dryRun {
+			continue
+		}
+		if _, err := r.RunGitCommand(ctx, change.GitArgs(), stdout, stderr, RetryPolicy{}); err != nil {
+			return fmt.Errorf("Could not %s remote %s: %w", change.Action, change.Remote, err)
+		}
+	}
+	if dryRun && len(changes) > 0 {
+		return fmt.Errorf("Remotes out of sync (%d changes)", len(changes))
+	}
+	return nil
+}
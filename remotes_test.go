@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Create a repository and run git commands in it
+func setupRemotesRepo(t *testing.T, commands ...[]string) *Repo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	local := filepath.Join(t.TempDir(), "repo")
+	commands = append([][]string{{"init", "-q", local}}, commands...)
+	for _, args := range commands {
+		if args[0] != "init" {
+			args = append([]string{"-C", local}, args...)
+		}
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %s %s", strings.Join(args, " "), err, out)
+		}
+	}
+	return &Repo{Name: "repo", Local: local}
+}
+
+// Return the values of a key of the repository configuration, as git reads them
+func gitConfigGetAll(t *testing.T, repo *Repo, key string) []string {
+	t.Helper()
+	out, _ := exec.Command("git", "-C", repo.Local, "config", "--get-all", key).Output()
+	return strings.Fields(string(out))
+}
+
+func TestRemoteWithSeveralURLs(t *testing.T) {
+	repo := setupRemotesRepo(t,
+		[]string{"remote", "add", "origin", "https://example.com/first.git"},
+		[]string{"config", "--add", "remote.origin.url", "https://example.com/second.git"},
+	)
+	if err := repo.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The URL of the remote is the first one, which git fetches from
+	if repo.Remote != "https://example.com/first.git" {
+		t.Errorf("Remote = %q, want the first url", repo.Remote)
+	}
+	want := map[string]RemoteURLs{"origin": {Fetch: "https://example.com/first.git"}}
+	if got := configRemotes(repo.Config); !reflect.DeepEqual(got, want) {
+		t.Errorf("configRemotes = %v, want %v", got, want)
+	}
+	changes, err := repo.DiffRemotes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("DiffRemotes = %v, want no change", changes)
+	}
+
+	// Changing the URL only replaces the first one
+	repo.Remote = "https://example.com/moved.git"
+	var stdout, stderr bytes.Buffer
+	if err := repo.SyncRemotes(context.Background(), &stdout, &stderr, false); err != nil {
+		t.Fatalf("SyncRemotes: %s\n%s", err, stderr.String())
+	}
+	urls := gitConfigGetAll(t, repo, "remote.origin.url")
+	if want := []string{"https://example.com/moved.git", "https://example.com/second.git"}; !reflect.DeepEqual(urls, want) {
+		t.Errorf("remote.origin.url = %q, want %q", urls, want)
+	}
+	if changes, err := repo.DiffRemotes(); err != nil || len(changes) != 0 {
+		t.Errorf("DiffRemotes after sync = %v, %v, want no change", changes, err)
+	}
+}
+
+func TestSyncRemotesPushURL(t *testing.T) {
+	repo := setupRemotesRepo(t,
+		[]string{"remote", "add", "origin", "https://example.com/a.git"},
+		[]string{"config", "--add", "remote.origin.pushurl", "git@example.com:a.git"},
+		[]string{"config", "--add", "remote.origin.pushurl", "git@backup.example.com:a.git"},
+	)
+	repo.Remotes = map[string]RemoteURLs{"origin": {Fetch: "https://example.com/a.git", Push: "git@example.com:b.git"}}
+
+	var stdout, stderr bytes.Buffer
+	if err := repo.SyncRemotes(context.Background(), &stdout, &stderr, false); err != nil {
+		t.Fatalf("SyncRemotes: %s\n%s", err, stderr.String())
+	}
+	urls := gitConfigGetAll(t, repo, "remote.origin.pushurl")
+	if want := []string{"git@example.com:b.git", "git@backup.example.com:a.git"}; !reflect.DeepEqual(urls, want) {
+		t.Errorf("remote.origin.pushurl = %q, want %q", urls, want)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Struct ResultRecord is the machine-readable form of a RepoResult
+// It is printed by the json and ndjson output modes
+type ResultRecord struct {
+	Name       string     `json:"name"`
+	Local      string     `json:"local"`
+	Argv       []string   `json:"argv"`
+	Status     string     `json:"status"`
+	ExitCode   *int       `json:"exit_code"` // null if the command was not started
+	Attempts   int        `json:"attempts"`
+	Error      string     `json:"error,omitempty"`
+	Stdout     string     `json:"stdout"`
+	Stderr     string     `json:"stderr"`
+	Start      *time.Time `json:"start,omitempty"`
+	End        *time.Time `json:"end,omitempty"`
+	DurationMs int64      `json:"duration_ms"`
+}
+
+// Create the record of a result
+func NewResultRecord(res *RepoResult) ResultRecord {
+	record := ResultRecord{
+		Name:     res.Repo.Name,
+		Local:    res.Repo.Local,
+		Argv:     append([]string{"git"}, res.Args...),
+		Status:   res.Status,
+		Attempts: res.Attempts,
+		Stdout:   res.Stdout.String(),
+		Stderr:   res.Stderr.String(),
+	}
+	if res.Err != nil {
+		record.Error = res.Err.Error()
+	}
+	if res.Started() {
+		exitCode, start, end := res.ExitCode, res.Start, res.End
+		record.ExitCode = &exitCode
+		record.Start = &start
+		record.End = &end
+		record.DurationMs = res.Duration().Milliseconds()
+	}
+	return record
+}
+
+// Print a result as a single line of JSON (ndjson output mode)
+func PrintResultNDJSON(res *RepoResult) {
+	data, err := json.Marshal(NewResultRecord(res))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, ColorOutput(ColorRed, fmt.Sprintf("Error marshalling result to JSON: %s", err)))
+		return
+	}
+	fmt.Println(string(data))
+}
+
+// Print all the results as a JSON array (json output mode)
+func PrintResultsJSON(results []*RepoResult) {
+	records := make([]ResultRecord, len(results))
+	for i, res := range results {
+		records[i] = NewResultRecord(res)
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, ColorOutput(ColorRed, fmt.Sprintf("Error marshalling results to JSON: %s", err)))
+		return
+	}
+	fmt.Println(string(data))
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,6 +9,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // Struct Repo describes a git repository
@@ -16,161 +17,143 @@ type Repo struct {
 	Name   string            `json:"name"`
 	Local  string            `json:"local"`
 	Remote string            `json:"remote,omitempty"`
-	Config map[string]interface{} `json:"config"`
+	RemoteName string        `json:"remote_name,omitempty"` // Name of the primary remote, see PrimaryRemote
+	Remotes map[string]RemoteURLs `json:"remotes,omitempty"` // All the remotes of the repository by name, see DeclaredRemotes
+	Timeout string           `json:"timeout,omitempty"`
+	Groups []string          `json:"groups,omitempty"`
+	Tags   []string          `json:"tags,omitempty"`
+	WorktreeOf string        `json:"worktree_of,omitempty"` // Name of the repository this one is a linked worktree of
+	Bare   bool              `json:"bare,omitempty"`   // Repository without a work tree, whose configuration is <local>/config
+	Mirror bool              `json:"mirror,omitempty"` // Bare repository cloned with --mirror
+	Config *GitConfig        `json:"config,omitempty"`
 }
 
-// Get the value of a key in the Config map
-func (r *Repo) GetConfigValue(key string) (string, error) {
-	parts := strings.Split(key, ".")
-	if len(parts) < 2 {
-		return "", fmt.Errorf("Invalid key format. Expected format: section.key or section.subsection.key")
-	}
+// Name of the primary remote when nothing else is configured
+const DefaultRemoteName = "origin"
 
-	section := parts[0]
-	sectionMap, sectionExists := r.Config[section]
-	if !sectionExists {
-		return "", fmt.Errorf("Section %s not found", section)
+// Name of the primary remote of the repositories that do not set one
+// It is DefaultRemoteName unless the settings give another one, see Settings.RemoteName
+var PrimaryRemoteName = DefaultRemoteName
+
+// Return the name of the primary remote of the repository
+// The primary remote is the one whose URL is the Remote of the repository
+func (r *Repo) PrimaryRemote() string {
+	if r.RemoteName != "" {
+		return r.RemoteName
 	}
+	return PrimaryRemoteName
+}
 
-	if len(parts) == 2 {
-		// No subsection, just section.key
-		key := parts[1]
-		value, keyExists := sectionMap.(map[string]string)[key]
-		if !keyExists {
-			return "", fmt.Errorf("Key %s not found in section %s", key, section)
+// Find the URL of the primary remote in the Config, see PrimaryRemote
+// If the repository has no such remote but has others, and its primary remote
+// is not set with Repo.RemoteName, the first one is used and becomes the
+// primary remote. A repository without remotes has no URL.
+func (r *Repo) findRemote() {
+	if url, ok := remoteURL(r.Config, r.PrimaryRemote()); ok {
+		r.Remote = url
+		return
+	}
+	if r.RemoteName != "" {
+		return
+	}
+	for _, remote := range r.Config.Subsections("remote") {
+		if url, ok := remoteURL(r.Config, remote); ok {
+			r.RemoteName, r.Remote = remote, url
+			return
 		}
-		return value, nil
-	} else if len(parts) == 3 {
-		// With subsection, section.subsection.key
-		subsection := parts[1]
-		key := parts[2]
-		subsectionMap, subsectionExists := sectionMap.(map[string]interface{})[subsection]
-		if !subsectionExists {
-			return "", fmt.Errorf("Subsection %s not found in section %s", subsection, section)
+	}
+}
+
+// Return the groups and the tags of the repository, without duplicates
+// Groups and tags are equivalent, both can be selected with @name
+func (r *Repo) AllGroups() []string {
+	var groups []string
+	seen := make(map[string]bool)
+	for _, group := range append(append([]string{}, r.Groups...), r.Tags...) {
+		if !seen[group] {
+			seen[group] = true
+			groups = append(groups, group)
 		}
-		value, keyExists := subsectionMap.(map[string]string)[key]
-		if !keyExists {
-			return "", fmt.Errorf("Key %s not found in subsection %s of section %s", key, subsection, section)
+	}
+	return groups
+}
+
+// Check whether the repository belongs to a group (or has a tag)
+func (r *Repo) InGroup(group string) bool {
+	for _, g := range r.AllGroups() {
+		if g == group {
+			return true
 		}
-		return value, nil
 	}
+	return false
+}
+
+// Get the timeout of the commands executed in the repository
+// The timeout is a duration such as "30s" or "2m"; 0 is returned if it is not set
+func (r *Repo) GetTimeout() (time.Duration, error) {
+	if r.Timeout == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(r.Timeout)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("Invalid timeout '%s' for repository %s", r.Timeout, r.Name)
+	}
+	return timeout, nil
+}
 
-	return "", fmt.Errorf("Invalid key format")
+// Get the value of a key in the Config, e.g. remote.origin.url
+// See GitConfig for the typed getters
+func (r *Repo) GetConfigValue(key string) (string, error) {
+	if _, _, _, err := splitConfigKey(key); err != nil {
+		return "", err
+	}
+	value, ok := r.Config.Get(key)
+	if !ok {
+		return "", fmt.Errorf("Key %s not found", key)
+	}
+	return value, nil
 }
 
 // Load the configuration of a repository
-// The configuration is stored in the .git/config file of the repository
-// The function reads the file and stores the key-value pairs in the Config map
+// The configuration is stored in the config file of the common directory of
+// the repository (see ResolveGitDir), i.e. .git/config except for linked worktrees
+// The function reads the file, with the files it includes (see loadGitConfig),
+// and stores its entries in the Config
 func (r *Repo) LoadConfig() error {
-	configFile := filepath.Join(r.Local, ".git", "config")
-	config, err := parseGitConfig(configFile)
+	gitDir, commonDir, err := r.ResolveGitDir()
 	if err != nil {
 		return err
 	}
-
-	r.Config = config
-
-	// Set the remote URL
-	r.Remote, err = r.GetConfigValue("remote.origin.url")
+	configFile := filepath.Join(commonDir, "config")
+	entries, err := loadGitConfig(configFile, gitDir)
 	if err != nil {
-		return fmt.Errorf("Could not get remote URL: %s", err)
-	}
-
-	return nil
-}
-
-// Parse the .git/config file and return the configuration map
-func parseGitConfig(configFile string) (map[string]interface{}, error) {
-	config := make(map[string]interface{})
-
-	file, err := os.Open(configFile)
-	if err != nil {
-		return nil, fmt.Errorf("Could not open %s: %s", configFile, err)
+		return err
 	}
-	defer file.Close()
-
-	var currentSection string
-	var currentSubsection string
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if len(line) == 0 || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
-			// Skip empty lines and comments
-			continue
-		}
-
-		if strings.HasPrefix(line, "[") {
-			// New section
-			sectionParts := strings.Split(line[1:len(line)-1], " ")
-			currentSection = sectionParts[0]
-			if len(sectionParts) > 1 {
-				currentSubsection = strings.Trim(sectionParts[1], "\"")
-			} else {
-				currentSubsection = ""
-			}
-		} else if strings.Contains(line, "=") {
-			// Key-value pair
-			parts := strings.SplitN(line, "=", 2)
-			key := strings.TrimSpace(parts[0])
-			value := strings.TrimSpace(parts[1])
 
-			key = strings.Trim(key, "\"")
+	r.Config = NewGitConfig(configFile, entries)
 
-			if currentSubsection != "" {
-				if _, exists := config[currentSection]; !exists {
-					config[currentSection] = make(map[string]interface{})
-				}
-				subsectionMap := config[currentSection].(map[string]interface{})
-				if _, exists := subsectionMap[currentSubsection]; !exists {
-					subsectionMap[currentSubsection] = make(map[string]string)
-				}
-				subsectionConfig := subsectionMap[currentSubsection].(map[string]string)
-				subsectionConfig[key] = value
-			} else {
-				if _, exists := config[currentSection]; !exists {
-					config[currentSection] = make(map[string]string)
-				}
-				sectionConfig := config[currentSection].(map[string]string)
-				sectionConfig[key] = value
-			}
-		}
+	// Set the remote URL from the configuration, if it is not declared
+	if r.Remote == "" {
+		r.findRemote()
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("Error reading config file: %s", err)
-	}
-
-	return config, nil
+	return nil
 }
 
 // Export the Repos slice to a JSON string
-// If includeConfig is false, the Config field is omitted
+// If includeConfig is false, the Config field is omitted, and the other fields
+// are written as in repos.json
 func ReposToJSON(repos []Repo, includeConfig bool) (string, error) {
-	if includeConfig {
-		jsonData, err := json.MarshalIndent(repos, "", "  ")
-		if err != nil {
-			return "", fmt.Errorf("Error marshalling repos to JSON: %s", err)
+	if !includeConfig {
+		entries := make([]Repo, len(repos))
+		for i, repo := range repos {
+			entries[i] = repo
+			entries[i].Config = nil
 		}
-		return string(jsonData), nil
+		repos = entries
 	}
 
-	type RepoWithoutConfig struct {
-		Name   string `json:"name"`
-		Local  string `json:"local"`
-		Remote string `json:"remote,omitempty"`
-	}
-
-	reposWithoutConfig := make([]RepoWithoutConfig, len(repos))
-	for i, repo := range repos {
-		reposWithoutConfig[i] = RepoWithoutConfig{
-			Name:   repo.Name,
-			Local:  repo.Local,
-			Remote: repo.Remote,
-		}
-	}
-
-	jsonData, err := json.MarshalIndent(reposWithoutConfig, "", "  ")
+	jsonData, err := json.MarshalIndent(repos, "", "  ")
 	if err != nil {
 		return "", fmt.Errorf("Error marshalling repos to JSON: %s", err)
 	}
@@ -210,12 +193,30 @@ func LoadReposFromJSON(file string) ([]Repo, error) {
 		return nil, fmt.Errorf("Error parsing JSON: %s", err)
 	}
 
-	// Fill the Config map for each repository
+	// Fill the Config of each repository
 	for i := range repos {
 		repo := &repos[i]
+		if _, err := repo.GetTimeout(); err != nil {
+			return nil, err
+		}
+		if err := repo.checkRemotes(); err != nil {
+			return nil, err
+		}
+		if repo.IsWorktree() {
+			parent := FindRepo(repos, repo.WorktreeOf)
+			if parent == nil || parent.Name == repo.Name || parent.IsWorktree() {
+				return nil, fmt.Errorf("Repository %s is a worktree of %s, which is not a main repository in %s", repo.Name, repo.WorktreeOf, file)
+			}
+			if repo.Bare || repo.Mirror {
+				return nil, fmt.Errorf("Repository %s is a worktree of %s, and cannot be bare", repo.Name, repo.WorktreeOf)
+			}
+		}
 		err = repo.LoadConfig()
 		if err != nil {
-			fmt.Println(ColorOutput(ColorYellow, fmt.Sprintf("[Warning]: %s -- Have you cloned this repository? Run <gogit clone>", err)))
+			// Missing repositories are reported by the commands (e.g. gogit status), not at load time
+			if _, statErr := os.Stat(repo.Local); !os.IsNotExist(statErr) {
+				fmt.Fprintln(os.Stderr, ColorOutput(ColorYellow, fmt.Sprintf("[Warning]: %s", err)))
+			}
 			repo.Config = nil // Ensure the Config is nil if it could not be loaded
 		}
 	}
@@ -234,8 +235,12 @@ func MakeReposFromRoot(root string) ([]Repo, error) {
 		}
 
 		if info.IsDir() {
-			// Check if the directory is a git repository
-			if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+			// The git directory of a repository that could not be read is not a bare repository
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			// Check if the directory is a git repository, or a bare one
+			if _, err := os.Stat(filepath.Join(path, ".git")); err == nil || isGitDir(path) {
 				// Create a Repo from the directory
 				repo, err := MakeRepoFromLocal(path)
 				if err != nil {
@@ -255,6 +260,25 @@ func MakeReposFromRoot(root string) ([]Repo, error) {
 		return nil, fmt.Errorf("Error walking the path %s: %s", root, err)
 	}
 
+	// Linked worktrees share the common directory of their main repository
+	mainRepos := make(map[string]string)
+	commonDirs := make([]string, len(repos))
+	for i := range repos {
+		gitDir, commonDir, err := repos[i].ResolveGitDir()
+		if err != nil {
+			continue
+		}
+		commonDirs[i] = commonDir
+		if gitDir == commonDir {
+			mainRepos[commonDir] = repos[i].Name
+		}
+	}
+	for i := range repos {
+		if parent, ok := mainRepos[commonDirs[i]]; ok && parent != repos[i].Name {
+			repos[i].WorktreeOf = parent
+		}
+	}
+
 	return repos, nil
 }
 
@@ -274,56 +298,208 @@ func MakeRepoFromLocal(dir string) (*Repo, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Could not get absolute path of %s: %s", dir, err)
 	}
-	// Check if the directory is a git repository
-	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
-		return nil, fmt.Errorf("Directory is not a git repository: %s", dir)
+	// Check if the directory is a git repository, and find its git directories
+	gitDir, commonDir, err := ResolveGitDir(dir)
+	if err != nil {
+		return nil, err
 	}
 	// Set the local path of the repository
 	repo.Local = dir
 
-	// Set the name of the directory
+	// Set the name of the directory, without the .git suffix of bare repositories
 	repo.Name = filepath.Base(dir)
+	repo.Bare = gitDir == dir
+	if repo.Bare {
+		repo.Name = strings.TrimSuffix(repo.Name, ".git")
+	}
 
-	// Read the .git/config file and store the key/values in the Config map
-	config, err := parseGitConfig(filepath.Join(dir, ".git", "config"))
+	// Read the config file and store its entries in the Config
+	configFile := filepath.Join(commonDir, "config")
+	entries, err := loadGitConfig(configFile, gitDir)
 	if err != nil {
 		return nil, err
 	}
 
-	repo.Config = config
+	repo.Config = NewGitConfig(configFile, entries)
 
-	// Set the remote URL
-	repo.Remote, err = repo.GetConfigValue("remote.origin.url")
-	if err != nil {
-		return nil, fmt.Errorf("Could not get remote URL: %s", err)
+	// Set the remote URL, the repository may have no remote
+	repo.findRemote()
+	// The other remotes are declared as well, so that they can be cloned and synced
+	if remotes := configRemotes(repo.Config); len(remotes) > 1 {
+		repo.Remotes = remotes
+	}
+	if repo.Bare && repo.Remote != "" {
+		repo.Mirror, _ = repo.Config.GetBool("remote."+repo.PrimaryRemote()+".mirror", false)
 	}
 
 	return repo, nil
 }
 
+// Return the arguments of the git clone command of the repository
+// Bare repositories and mirrors are cloned with --bare and --mirror, and the
+// remote is named after the primary remote of the repository
+func (r *Repo) CloneArgs() []string {
+	args := []string{"clone"}
+	if r.Mirror {
+		args = append(args, "--mirror")
+	} else if r.Bare {
+		args = append(args, "--bare")
+	}
+	if name := r.PrimaryRemote(); name != DefaultRemoteName {
+		args = append(args, "--origin", name)
+	}
+	return append(args, r.Remote, r.Local)
+}
+
 // Clone the repository
 // The function runs the git clone command to clone the repository from the remote URL
-// into the local path
-func (r *Repo) Clone() error {
-	cmd := exec.Command("git", "clone", r.Remote, r.Local)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
+// into the local path, see CloneArgs
+// The output of the command is written to the given stdout and stderr writers
+// The clone is retried on transient network failures according to the policy
+// Returns the number of attempts
+func (r *Repo) Clone(ctx context.Context, stdout, stderr io.Writer, retry RetryPolicy) (int, error) {
+	attempts, err := retry.Run(ctx, stderr, func(stderr io.Writer) error {
+		cmd := newGitCommand(ctx, "", r.CloneArgs()...)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		return cmd.Run()
+	})
 	if err != nil {
-		return fmt.Errorf("Error cloning repository: %s", err)
+		return attempts, fmt.Errorf("Error cloning repository: %w", err)
+	}
+	return attempts, nil
+}
+
+// Create the repository as a linked worktree of its parent repository, see Repo.WorktreeOf
+// The worktree is added with `git worktree add`, which checks out a new branch
+// named after the last element of the local path
+func (r *Repo) AddWorktree(ctx context.Context, parent *Repo, stdout, stderr io.Writer) error {
+	if _, err := os.Stat(parent.Local); os.IsNotExist(err) {
+		return fmt.Errorf("Parent repository %s is missing: %s does not exist", parent.Name, parent.Local)
+	}
+	local, err := filepath.Abs(r.Local)
+	if err != nil {
+		return fmt.Errorf("Could not get absolute path of %s: %s", r.Local, err)
+	}
+	cmd := newGitCommand(ctx, parent.Local, "worktree", "add", local)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error adding worktree: %w", err)
 	}
 	return nil
 }
 
 // Execute a git command
-func (r *Repo) RunGitCommand(args []string) error {
+// The output of the command is written to the given stdout and stderr writers
+// The command is interrupted when the context is cancelled, see newGitCommand
+// Network commands (see IsNetworkCommand) are retried on transient failures according to the policy
+// Returns the number of attempts
+func (r *Repo) RunGitCommand(ctx context.Context, args []string, stdout, stderr io.Writer, retry RetryPolicy) (int, error) {
+	if _, err := os.Stat(r.Local); os.IsNotExist(err) {
+		return 0, fmt.Errorf("Repository is missing: %s does not exist", r.Local)
+	}
+	if !IsNetworkCommand(args) {
+		retry.Retries = 0
+	}
+	attempts, err := retry.Run(ctx, stderr, func(stderr io.Writer) error {
+		cmd := newGitCommand(ctx, r.Local, args...)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		return cmd.Run()
+	})
+	if err != nil {
+		return attempts, fmt.Errorf("Error executing git command: %w", err)
+	}
+	return attempts, nil
+}
+
+// Execute a git command attached to the terminal
+// The command reads from stdin and writes to stdout and stderr, so that it can
+// prompt the user or open an editor. It stays in the process group of gogit,
+// as a command in another process group could not read from the terminal.
+func (r *Repo) RunInteractiveGitCommand(args []string) error {
 	cmd := exec.Command("git", args...)
 	cmd.Dir = r.Local
+	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	err := cmd.Run()
 	if err != nil {
-		return fmt.Errorf("Error executing git command: %s", err)
+		return fmt.Errorf("Error executing git command: %w", err)
 	}
 	return nil
 }
+
+// Return the environment of a git command that must not prompt the user
+// GIT_TERMINAL_PROMPT=0 makes git fail instead of asking for a username or a
+// password, and ssh runs in BatchMode so that it fails instead of asking for a
+// passphrase or to confirm a host key. As GIT_SSH_COMMAND takes precedence
+// over core.sshCommand, the ssh command configured for the repository (see
+// configuredSSHCommand) is kept, with BatchMode added to its options. The
+// variables already set by the user are kept, e.g. a custom GIT_SSH_COMMAND.
+func nonInteractiveEnv(env []string, sshCommand string) []string {
+	set := make(map[string]bool)
+	for _, kv := range env {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			set[kv[:i]] = true
+		}
+	}
+	if !set["GIT_TERMINAL_PROMPT"] {
+		env = append(env, "GIT_TERMINAL_PROMPT=0")
+	}
+	if !set["GIT_SSH_COMMAND"] && !set["GIT_SSH"] {
+		if sshCommand == "" {
+			sshCommand = "ssh"
+		}
+		env = append(env, "GIT_SSH_COMMAND="+sshCommand+" -o BatchMode=yes")
+	}
+	return env
+}
+
+// Return the ssh command set with core.sshCommand for the git commands run in
+// a directory, empty if there is none
+// The effective configuration of the repository is read, or only the
+// configuration files shared by all the repositories when the directory is not
+// a repository, e.g. for clone.
+func configuredSSHCommand(dir string) string {
+	var config *GitConfig
+	if dir != "" {
+		config, _ = (&Repo{Local: dir}).EffectiveConfig()
+	}
+	if config == nil {
+		var entries []ConfigEntry
+		for _, f := range sharedConfigFiles() {
+			if fileEntries, err := loadGitConfig(f.File, ""); err == nil {
+				entries = append(entries, fileEntries...)
+			}
+		}
+		config = NewGitConfig("", entries)
+	}
+	command, _ := config.Get("core.sshCommand")
+	return command
+}
+
+// Time given to a git process to exit after being interrupted, before it is killed
+const InterruptGracePeriod = 5 * time.Second
+
+// Create a git command bound to a context
+// The command runs in its own process group. When the context is cancelled, the
+// process group receives SIGINT so that git can clean up (e.g. remove index.lock);
+// if git has not exited after InterruptGracePeriod, it is killed.
+// As the command is detached from the terminal, it must not wait for the user:
+// git and ssh are told not to prompt for credentials, so that a repository
+// that needs them fails at once instead of hanging (see nonInteractiveEnv).
+// The ssh command configured for the repository with core.sshCommand is kept.
+func newGitCommand(ctx context.Context, dir string, args ...string) *exec.Cmd {
+	PrintVerbose("Running git %s in %s", strings.Join(args, " "), dir)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = nonInteractiveEnv(os.Environ(), configuredSSHCommand(dir))
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return interruptProcess(cmd)
+	}
+	cmd.WaitDelay = InterruptGracePeriod
+	return cmd
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNonInteractiveEnv(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        []string
+		sshCommand string
+		want       []string
+	}{
+		{
+			name: "defaults",
+			env:  []string{"HOME=/home/user"},
+			want: []string{"HOME=/home/user", "GIT_TERMINAL_PROMPT=0", "GIT_SSH_COMMAND=ssh -o BatchMode=yes"},
+		},
+		{
+			name:       "core.sshCommand",
+			env:        []string{"HOME=/home/user"},
+			sshCommand: "ssh -i ~/.ssh/deploy -p 2222",
+			want:       []string{"HOME=/home/user", "GIT_TERMINAL_PROMPT=0", "GIT_SSH_COMMAND=ssh -i ~/.ssh/deploy -p 2222 -o BatchMode=yes"},
+		},
+		{
+			name:       "GIT_SSH_COMMAND set by the user",
+			env:        []string{"GIT_SSH_COMMAND=my-ssh", "GIT_TERMINAL_PROMPT=1"},
+			sshCommand: "ssh -p 2222",
+			want:       []string{"GIT_SSH_COMMAND=my-ssh", "GIT_TERMINAL_PROMPT=1"},
+		},
+		{
+			name: "GIT_SSH set by the user",
+			env:  []string{"GIT_SSH=/usr/bin/my-ssh"},
+			want: []string{"GIT_SSH=/usr/bin/my-ssh", "GIT_TERMINAL_PROMPT=0"},
+		},
+	}
+	for _, tt := range tests {
+		got := nonInteractiveEnv(append([]string{}, tt.env...), tt.sshCommand)
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: nonInteractiveEnv = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRunGitCommandKeepsSSHCommand(t *testing.T) {
+	remote, repo := setupRetryRepos(t)
+	// Unset by the test, and restored after it by t.Setenv
+	for _, name := range []string{"GIT_SSH_COMMAND", "GIT_SSH"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	t.Setenv("GIT_SSH_VARIANT", "simple")
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+
+	// The ssh command of the repository records its arguments, then runs the
+	// git command it is given locally
+	dir := t.TempDir()
+	args := filepath.Join(dir, "args")
+	script := filepath.Join(dir, "deploy-ssh")
+	content := `#!/bin/sh
+echo "$@" > "` + args + `"
+for command; do :; done
+eval "$command"
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("git", "-C", repo.Local, "config", "core.sshCommand", script+" -i deploy_key").CombinedOutput(); err != nil {
+		t.Fatalf("git config: %s %s", err, out)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if _, err := repo.RunGitCommand(context.Background(), []string{"fetch", "ssh://example.com" + remote}, &stdout, &stderr, RetryPolicy{}); err != nil {
+		t.Fatalf("RunGitCommand: %s\n%s", err, stderr.String())
+	}
+	data, err := os.ReadFile(args)
+	if err != nil {
+		t.Fatalf("The ssh command of the repository was not run: %s", err)
+	}
+	if got := string(data); !strings.HasPrefix(got, "-i deploy_key -o BatchMode=yes example.com ") {
+		t.Errorf("ssh arguments = %q, want the options of core.sshCommand and BatchMode", got)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Default delays between the attempts of a retried command
+const (
+	DefaultRetryDelay    = time.Second
+	DefaultRetryMaxDelay = 30 * time.Second
+)
+
+// git commands that talk to a remote and may be retried
+var networkCommands = map[string]bool{
+	"clone":     true,
+	"fetch":     true,
+	"ls-remote": true,
+	"pull":      true,
+	"push":      true,
+}
+
+// Messages of git (or ssh) on stderr that denote a transient network failure
+// They are matched case-insensitively
+var transientErrors = []string{
+	"connection reset",
+	"connection refused",
+	"connection timed out",
+	"operation timed out",
+	"could not read from remote repository",
+	"the remote end hung up unexpectedly",
+	"early eof",
+	"rpc failed",
+	"could not resolve host",
+	"temporary failure in name resolution",
+	"kex_exchange_identification",
+	"ssh_exchange_identification",
+}
+
+// Messages of git (or ssh) on stderr that denote a permanent failure
+// git adds "Could not read from remote repository" to any failure of ssh, so
+// these take precedence over transientErrors. They are matched case-insensitively.
+var permanentErrors = []string{
+	"permission denied",
+	"authentication failed",
+	"host key verification failed",
+	"repository not found",
+	"does not appear to be a git repository",
+}
+
+// Struct RetryPolicy describes how a failing network command is retried
+// The delay before a retry doubles at each attempt, up to MaxDelay, and is
+// randomized (between half and all of it) so that the repositories do not
+// retry all at the same time
+type RetryPolicy struct {
+	Retries  int // Number of retries after the first attempt, 0 to disable retries
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// Default retry policy: no retry
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Delay:    DefaultRetryDelay,
+		MaxDelay: DefaultRetryMaxDelay,
+	}
+}
+
+// Return the delay before the given retry (1 for the first retry)
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	delay := p.Delay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Run a command according to the policy
+// The command is run again as long as it fails with a transient error (see
+// IsTransientError on what it wrote to stderr) and retries are left. A note is
+// written to stderr before each retry. The context interrupts the wait.
+// Returns the number of attempts and the error of the last one.
+func (p RetryPolicy) Run(ctx context.Context, stderr io.Writer, run func(stderr io.Writer) error) (int, error) {
+	attempt := 1
+	for {
+		var captured bytes.Buffer
+		err := run(io.MultiWriter(stderr, &captured))
+		if err == nil || attempt > p.Retries || ctx.Err() != nil || !IsTransientError(captured.String()) {
+			return attempt, err
+		}
+
+		delay := p.Backoff(attempt)
+		fmt.Fprintf(stderr, "gogit: transient error, retrying in %s (attempt %d of %d)\n", delay.Round(time.Millisecond), attempt+1, p.Retries+1)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		case <-timer.C:
+		}
+		attempt++
+	}
+}
+
+// Check whether the output of a failed git command denotes a transient network failure
+func IsTransientError(stderr string) bool {
+	stderr = strings.ToLower(stderr)
+	for _, message := range permanentErrors {
+		if strings.Contains(stderr, message) {
+			return false
+		}
+	}
+	for _, message := range transientErrors {
+		if strings.Contains(stderr, message) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check whether git arguments run a command that talks to a remote
+func IsNetworkCommand(args []string) bool {
+	return networkCommands[GitSubcommand(args)]
+}
+
+// Return the git subcommand of git arguments, skipping the global options of git
+// e.g. "fetch" for -c http.lowSpeedTime=10 fetch --all
+func GitSubcommand(args []string) string {
+	if i := gitSubcommandIndex(args); i >= 0 {
+		return args[i]
+	}
+	return ""
+}
+
+// Return the index of the git subcommand in git arguments, -1 if there is none
+func gitSubcommandIndex(args []string) int {
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-c", "-C", "--git-dir", "--work-tree", "--namespace":
+			i++ // Skip the value of the option
+		default:
+			if !strings.HasPrefix(args[i], "-") {
+				return i
+			}
+		}
+	}
+	return -1
+}
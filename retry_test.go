@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   bool
+	}{
+		{"fatal: unable to access 'https://example.com/repo.git/': Connection reset by peer", true},
+		{"ssh: connect to host example.com port 22: Connection refused", true},
+		{"ssh: connect to host example.com port 22: Connection timed out", true},
+		{"fatal: Could not read from remote repository.", true},
+		{"fatal: the remote end hung up unexpectedly", true},
+		{"fetch-pack: unexpected disconnect while reading sideband packet\nfatal: early EOF", true},
+		{"error: RPC failed; curl 56 GnuTLS recv error (-9)", true},
+		{"ssh: Could not resolve hostname example.com: Temporary failure in name resolution", true},
+		{"kex_exchange_identification: read: Connection reset by peer", true},
+		{"CONNECTION RESET", true},
+		{"fatal: repository 'https://example.com/missing.git/' not found", false},
+		{"git@example.com: Permission denied (publickey).", false},
+		{"git@example.com: Permission denied (publickey).\nfatal: Could not read from remote repository.", false},
+		{"Host key verification failed.\nfatal: Could not read from remote repository.", false},
+		{"ERROR: Repository not found.\nfatal: Could not read from remote repository.", false},
+		{"fatal: Authentication failed for 'https://example.com/repo.git/'", false},
+		{"error: pathspec 'missing' did not match any file(s) known to git", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsTransientError(tt.stderr); got != tt.want {
+			t.Errorf("IsTransientError(%q) = %v, want %v", tt.stderr, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{Delay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		retry int
+		max   time.Duration // The delay before randomization, which keeps between max/2 and max
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{10, time.Second},
+		{100, time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if got := policy.Backoff(tt.retry); got < tt.max/2 || got > tt.max {
+				t.Fatalf("Backoff(%d) = %s, want between %s and %s", tt.retry, got, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+func TestBackoffCap(t *testing.T) {
+	// The initial delay is already above the cap
+	policy := RetryPolicy{Delay: time.Minute, MaxDelay: time.Second}
+	for retry := 1; retry <= 3; retry++ {
+		if got := policy.Backoff(retry); got > time.Second {
+			t.Errorf("Backoff(%d) = %s, want at most %s", retry, got, time.Second)
+		}
+	}
+
+	policy = RetryPolicy{}
+	if got := policy.Backoff(1); got != 0 {
+		t.Errorf("Backoff(1) without delay = %s, want 0", got)
+	}
+}
+
+// Write a fake ssh command that fails with the given message the first n
+// times it is run, then runs the git command it is given locally
+// Returns the file counting the runs.
+func writeFlakySSH(t *testing.T, n int, message string) string {
+	t.Helper()
+	dir := t.TempDir()
+	count := filepath.Join(dir, "count")
+	script := filepath.Join(dir, "ssh")
+	content := `#!/bin/sh
+count=$(cat "` + count + `" 2>/dev/null || echo 0)
+count=$((count + 1))
+echo "$count" > "` + count + `"
+if [ "$count" -le ` + strconv.Itoa(n) + ` ]; then
+	echo "` + message + `" >&2
+	exit 255
+fi
+# Run the command given to ssh, e.g. git-upload-pack '/path/repo.git', locally
+for command; do :; done
+eval "$command"
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_SSH_COMMAND", script)
+	t.Setenv("GIT_SSH_VARIANT", "simple")
+	return count
+}
+
+// Create a repository with one commit, and a repository to fetch it into
+func setupRetryRepos(t *testing.T) (remote string, repo *Repo) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	remote = filepath.Join(dir, "remote")
+	local := filepath.Join(dir, "local")
+	for _, args := range [][]string{
+		{"init", "-q", remote},
+		{"-C", remote, "-c", "user.name=gogit", "-c", "user.email=gogit@example.com", "commit", "-q", "--allow-empty", "-m", "initial"},
+		{"init", "-q", local},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %s %s", strings.Join(args, " "), err, out)
+		}
+	}
+	return remote, &Repo{Name: "local", Local: local}
+}
+
+func TestRunGitCommandRetries(t *testing.T) {
+	remote, repo := setupRetryRepos(t)
+	count := writeFlakySSH(t, 2, "Connection reset by peer")
+	policy := RetryPolicy{Retries: 3, Delay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	var stdout, stderr bytes.Buffer
+	attempts, err := repo.RunGitCommand(context.Background(), []string{"fetch", "ssh://example.com" + remote}, &stdout, &stderr, policy)
+	if err != nil {
+		t.Fatalf("RunGitCommand: %s\n%s", err, stderr.String())
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+	if got := strings.Count(stderr.String(), "gogit: transient error, retrying"); got != 2 {
+		t.Errorf("%d retry notes on stderr, want 2:\n%s", got, stderr.String())
+	}
+	if data, _ := os.ReadFile(count); strings.TrimSpace(string(data)) != "3" {
+		t.Errorf("ssh ran %s times, want 3", strings.TrimSpace(string(data)))
+	}
+}
+
+func TestRunGitCommandRetriesExhausted(t *testing.T) {
+	remote, repo := setupRetryRepos(t)
+	writeFlakySSH(t, 10, "Connection reset by peer")
+	policy := RetryPolicy{Retries: 2, Delay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	var stdout, stderr bytes.Buffer
+	attempts, err := repo.RunGitCommand(context.Background(), []string{"fetch", "ssh://example.com" + remote}, &stdout, &stderr, policy)
+	if err == nil {
+		t.Fatal("RunGitCommand succeeded, want an error")
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+}
+
+func TestRunGitCommandPermanentError(t *testing.T) {
+	remote, repo := setupRetryRepos(t)
+	count := writeFlakySSH(t, 10, "Permission denied (publickey).")
+	policy := RetryPolicy{Retries: 3, Delay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	var stdout, stderr bytes.Buffer
+	attempts, err := repo.RunGitCommand(context.Background(), []string{"fetch", "ssh://example.com" + remote}, &stdout, &stderr, policy)
+	if err == nil {
+		t.Fatal("RunGitCommand succeeded, want an error")
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+	if data, _ := os.ReadFile(count); strings.TrimSpace(string(data)) != "1" {
+		t.Errorf("ssh ran %s times, want 1", strings.TrimSpace(string(data)))
+	}
+}
+
+func TestRunGitCommandNotNetwork(t *testing.T) {
+	_, repo := setupRetryRepos(t)
+	policy := RetryPolicy{Retries: 3, Delay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	// A local command is not retried, even if its output looks transient
+	var stdout, stderr bytes.Buffer
+	attempts, err := repo.RunGitCommand(context.Background(), []string{"-c", "alias.flaky=!echo 'Connection reset' >&2; false", "flaky"}, &stdout, &stderr, policy)
+	if err == nil {
+		t.Fatal("RunGitCommand succeeded, want an error")
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Prefix of the selectors that designate a group of repositories, e.g. @backend
+const GroupPrefix = "@"
+
+// Prefix of the selectors that are regular expressions on the repository name, e.g. re:^api-v[0-9]+$
+const RegexPrefix = "re:"
+
+// Prefix of the selectors that exclude repositories, e.g. !legacy-*
+const ExcludePrefix = "!"
+
+// Separator of the terms of a selector, e.g. api-*,@infra,!legacy-*
+const SelectorSeparator = ","
+
+// Check whether an argument, guessed to be a selector, designates repositories
+// Only the exact name of a repository or a group (@group) with repositories is
+// accepted: a glob, a regular expression, a path or a list could as well be an
+// argument of git, e.g. a pathspec. The other selectors must be given with
+// --repo (see Invocation.Selector).
+func IsRepoSelector(repos []Repo, arg string) bool {
+	group := strings.TrimPrefix(arg, GroupPrefix)
+	for _, repo := range repos {
+		if repo.Name == arg || (group != arg && repo.InGroup(group)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Select the repositories designated by a selector
+// A selector is a comma-separated list of terms. Each term is one of:
+//   - the name of a repository
+//   - a shell-style glob on the name, e.g. api-*
+//   - a regular expression on the name, prefixed with re:, e.g. re:^api-v[0-9]+$
+//   - @group, to select all the repositories of a group (see Repo.InGroup)
+//   - a path, starting with /, ./, ../ or ~/, to select the repositories located under it
+//
+// A term prefixed with ! excludes the repositories it matches instead of
+// selecting them. If a selector only has exclusions, they apply to all the
+// repositories. An empty selector selects all the repositories.
+// Every term that is not an exclusion must match at least one repository.
+// The repositories are returned in the order of the repos slice.
+func SelectRepos(repos []Repo, selector string) ([]Repo, error) {
+	if selector == "" {
+		return repos, nil
+	}
+
+	included := make([]bool, len(repos))
+	excluded := make([]bool, len(repos))
+	hasInclusions := false
+	for _, term := range strings.Split(selector, SelectorSeparator) {
+		exclude := strings.HasPrefix(term, ExcludePrefix)
+		term = strings.TrimPrefix(term, ExcludePrefix)
+		if term == "" {
+			return nil, fmt.Errorf("Empty term in selector '%s'", selector)
+		}
+		match, err := newRepoMatcher(term)
+		if err != nil {
+			return nil, err
+		}
+
+		matched := false
+		for i := range repos {
+			if match(&repos[i]) {
+				matched = true
+				if exclude {
+					excluded[i] = true
+				} else {
+					included[i] = true
+				}
+			}
+		}
+		if !exclude {
+			hasInclusions = true
+			if !matched {
+				return nil, noMatchError(term)
+			}
+		}
+	}
+
+	var selected []Repo
+	for i, repo := range repos {
+		if (included[i] || !hasInclusions) && !excluded[i] {
+			selected = append(selected, repo)
+		}
+	}
+	return selected, nil
+}
+
+// Return a function matching the repositories designated by a term of a selector
+func newRepoMatcher(term string) (func(*Repo) bool, error) {
+	switch {
+	case strings.HasPrefix(term, GroupPrefix):
+		group := strings.TrimPrefix(term, GroupPrefix)
+		return func(repo *Repo) bool {
+			return repo.InGroup(group)
+		}, nil
+
+	case strings.HasPrefix(term, RegexPrefix):
+		re, err := regexp.Compile(strings.TrimPrefix(term, RegexPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regular expression '%s': %s", term, err)
+		}
+		return func(repo *Repo) bool {
+			return re.MatchString(repo.Name)
+		}, nil
+
+	case isPathTerm(term):
+		prefix, err := expandPath(term)
+		if err != nil {
+			return nil, err
+		}
+		return func(repo *Repo) bool {
+			local := filepath.Clean(repo.Local)
+			return local == prefix || strings.HasPrefix(local, strings.TrimSuffix(prefix, string(filepath.Separator))+string(filepath.Separator))
+		}, nil
+
+	case strings.ContainsAny(term, "*?["):
+		if _, err := path.Match(term, ""); err != nil {
+			return nil, fmt.Errorf("Invalid pattern '%s': %s", term, err)
+		}
+		return func(repo *Repo) bool {
+			matched, _ := path.Match(term, repo.Name)
+			return matched
+		}, nil
+	}
+
+	return func(repo *Repo) bool {
+		return repo.Name == term
+	}, nil
+}
+
+// Return the error of a term of a selector that matches no repository
+func noMatchError(term string) error {
+	switch {
+	case strings.HasPrefix(term, GroupPrefix):
+		return fmt.Errorf("Group '%s' not found", strings.TrimPrefix(term, GroupPrefix))
+	case strings.HasPrefix(term, RegexPrefix), isPathTerm(term), strings.ContainsAny(term, "*?["):
+		return fmt.Errorf("No repository matches '%s'", term)
+	}
+	return fmt.Errorf("Repository '%s' not found", term)
+}
+
+// Check whether a term of a selector is a path
+func isPathTerm(term string) bool {
+	for _, prefix := range []string{"/", "./", "../", "~/"} {
+		if strings.HasPrefix(term, prefix) {
+			return true
+		}
+	}
+	return filepath.IsAbs(term)
+}
+
+// Return the absolute, clean form of a path, expanding a leading ~ to the home directory
+func expandPath(p string) (string, error) {
+	if strings.HasPrefix(p, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("Could not expand %s: %s", p, err)
+		}
+		p = filepath.Join(home, p[2:])
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", fmt.Errorf("Could not get absolute path of %s: %s", p, err)
+	}
+	return abs, nil
+}
+
+// Return the groups of the repositories with the number of repositories in each
+// The groups are sorted by name
+func ListRepoGroups(repos []Repo) ([]string, map[string]int) {
+	counts := make(map[string]int)
+	for _, repo := range repos {
+		for _, group := range repo.AllGroups() {
+			counts[group]++
+		}
+	}
+	groups := make([]string, 0, len(counts))
+	for group := range counts {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups, counts
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Struct Settings describes the user settings of gogit
+// The settings are stored in the settings.json file of the user configuration directory
+// All the fields are optional, a zero value means that the built-in default is used
+type Settings struct {
+	Jobs       int    `json:"jobs,omitempty"`
+	Timeout    string `json:"timeout,omitempty"`     // Duration, e.g. "30s" or "2m"
+	Retries    int    `json:"retries,omitempty"`     // Number of retries of the network commands
+	RetryDelay string `json:"retry_delay,omitempty"` // Delay before the first retry, e.g. "2s"
+	RemoteName string `json:"remote_name,omitempty"` // Name of the primary remote, "origin" by default, see Repo.PrimaryRemote
+}
+
+// Load the settings file
+// The file is located in the OS user's configuration directory, i.e. ~/.config/gogit/settings.json
+// A missing file is not an error, empty settings are returned
+func LoadSettings() (Settings, error) {
+	var settings Settings
+
+	settingsFile := filepath.Join(GetUserConfigDir(), "settings.json")
+	file, err := os.ReadFile(settingsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return settings, nil
+		}
+		return settings, fmt.Errorf("Could not read %s: %s", settingsFile, err)
+	}
+
+	err = json.Unmarshal(file, &settings)
+	if err != nil {
+		return settings, fmt.Errorf("Error parsing %s: %s", settingsFile, err)
+	}
+
+	return settings, nil
+}
+
+// Apply the settings that are not options of a command, but state shared by
+// all the commands, e.g. the name of the primary remote (see PrimaryRemoteName)
+// The other settings are the defaults of the options, see DefaultExecOptions.
+func (s Settings) Apply() {
+	if s.RemoteName != "" {
+		PrimaryRemoteName = s.RemoteName
+	}
+}
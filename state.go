@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operations that can be in progress in a repository, by the file or directory
+// that git keeps in the git directory while they are running
+var inProgressOperations = []struct {
+	file      string
+	operation string
+}{
+	{"rebase-merge", "rebase"},
+	{filepath.Join("rebase-apply", "applying"), "am"},
+	{"rebase-apply", "rebase"},
+	{"MERGE_HEAD", "merge"},
+	{"CHERRY_PICK_HEAD", "cherry-pick"},
+	{"REVERT_HEAD", "revert"},
+	{"BISECT_LOG", "bisect"},
+}
+
+// Struct RepoState describes the live state of a repository
+type RepoState struct {
+	Missing    bool   // The local path does not exist, the other fields are not set
+	Branch     string // Empty if HEAD is detached
+	Detached   bool
+	Upstream   string // Empty if the branch has no upstream
+	Ahead      int
+	Behind     int
+	Staged     int
+	Unstaged   int
+	Untracked  int
+	Conflicts  int
+	Stashes    int
+	Operation  string    // Operation in progress (rebase, merge...), empty if none
+	LastCommit time.Time // Zero if there is no commit yet
+}
+
+// Check whether the repository has changes, staged or not
+func (s *RepoState) IsDirty() bool {
+	return s.Staged > 0 || s.Unstaged > 0 || s.Untracked > 0 || s.Conflicts > 0
+}
+
+// Struct StateFilter selects repositories by their live state
+// A repository matches when it satisfies all the criteria that are set
+type StateFilter struct {
+	Dirty      bool
+	Clean      bool
+	Ahead      bool
+	Behind     bool
+	Diverged   bool // Both ahead and behind its upstream
+	OnBranch   string
+	Detached   bool
+	HasStash   bool
+	Missing    bool
+	NoUpstream bool
+}
+
+// Check whether no criterion is set
+func (f StateFilter) IsEmpty() bool {
+	return f == StateFilter{}
+}
+
+// Check whether a state satisfies all the criteria of the filter
+// A missing repository only matches the Missing criterion
+func (f StateFilter) Match(state *RepoState) bool {
+	if state.Missing {
+		return f.Missing && f == StateFilter{Missing: true}
+	}
+	return !f.Missing &&
+		(!f.Dirty || state.IsDirty()) &&
+		(!f.Clean || !state.IsDirty()) &&
+		(!f.Ahead || state.Ahead > 0) &&
+		(!f.Behind || state.Behind > 0) &&
+		(!f.Diverged || (state.Ahead > 0 && state.Behind > 0)) &&
+		(f.OnBranch == "" || state.Branch == f.OnBranch) &&
+		(!f.Detached || state.Detached) &&
+		(!f.HasStash || state.Stashes > 0) &&
+		(!f.NoUpstream || (state.Upstream == "" && !state.Detached))
+}
+
+// Keep the repositories whose live state matches opts.Filter
+// The states are probed in parallel, see ProbeInRepos. The repositories whose
+// state could not be read (including the probes skipped or interrupted, e.g. by
+// Ctrl-C) are left out, with a warning on stderr.
+func FilterReposByState(ctx context.Context, repos []Repo, opts ExecOptions) []Repo {
+	if opts.Filter.IsEmpty() {
+		return repos
+	}
+	repos = SkipBareRepos(repos, "the state filters need a work tree")
+	var filtered []Repo
+	for _, res := range ProbeInRepos(ctx, repos, opts) {
+		// A probe that was skipped or interrupted has no state, it is an error
+		// rather than a state that does not match
+		if res.Status != StatusOK {
+			reason := res.Status
+			if res.Err != nil {
+				reason = res.Err.Error()
+			}
+			fmt.Fprintln(os.Stderr, ColorOutput(ColorYellow, fmt.Sprintf("[Warning]: Could not read the state of %s: %s", res.Repo.Name, reason)))
+			continue
+		}
+		if opts.Filter.Match(res.State) {
+			filtered = append(filtered, res.Repo)
+		}
+	}
+	return filtered
+}
+
+// Probe the live state of a repository
+// The state is read with a few read-only git commands. git status runs with
+// --no-optional-locks, so that it does not refresh the index and does not
+// compete for index.lock with the commands the user runs in the repository.
+func (r *Repo) ProbeState(ctx context.Context) (*RepoState, error) {
+	state := &RepoState{}
+	if _, err := os.Stat(r.Local); os.IsNotExist(err) {
+		state.Missing = true
+		return state, nil
+	}
+
+	// Branch, upstream, ahead/behind and changes
+	output, err := r.gitOutput(ctx, "--no-optional-locks", "status", "--porcelain=v2", "--branch")
+	if err != nil {
+		return nil, err
+	}
+	initial := false
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "#":
+			value := strings.TrimSpace(strings.TrimPrefix(line, "# "+fields[1]))
+			switch fields[1] {
+			case "branch.oid":
+				initial = value == "(initial)"
+			case "branch.head":
+				if value == "(detached)" {
+					state.Detached = true
+				} else {
+					state.Branch = value
+				}
+			case "branch.upstream":
+				state.Upstream = value
+			case "branch.ab":
+				if len(fields) == 4 {
+					state.Ahead, _ = strconv.Atoi(strings.TrimPrefix(fields[2], "+"))
+					state.Behind, _ = strconv.Atoi(strings.TrimPrefix(fields[3], "-"))
+				}
+			}
+		case "1", "2":
+			// fields[1] is XY: X is the staged status, Y the unstaged one, "." if unchanged
+			if fields[1][0] != '.' {
+				state.Staged++
+			}
+			if len(fields[1]) > 1 && fields[1][1] != '.' {
+				state.Unstaged++
+			}
+		case "u":
+			state.Conflicts++
+		case "?":
+			state.Untracked++
+		}
+	}
+
+	// Stashes
+	output, err = r.gitOutput(ctx, "stash", "list", "--format=%H")
+	if err != nil {
+		return nil, err
+	}
+	state.Stashes = len(strings.Fields(output))
+
+	// Date of the last commit
+	if !initial {
+		output, err = r.gitOutput(ctx, "log", "-1", "--format=%ct")
+		if err != nil {
+			return nil, err
+		}
+		timestamp, err := strconv.ParseInt(strings.TrimSpace(output), 10, 64)
+		if err == nil {
+			state.LastCommit = time.Unix(timestamp, 0)
+		}
+	}
+
+	// Operation in progress
+	gitDir, err := r.gitOutput(ctx, "rev-parse", "--absolute-git-dir")
+	if err != nil {
+		return nil, err
+	}
+	gitDir = strings.TrimSpace(gitDir)
+	for _, op := range inProgressOperations {
+		if _, err := os.Stat(filepath.Join(gitDir, op.file)); err == nil {
+			state.Operation = op.operation
+			break
+		}
+	}
+
+	return state, nil
+}
+
+// Run a read-only git command in the repository and return its output
+// The error includes what git printed on stderr
+func (r *Repo) gitOutput(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	_, err := r.RunGitCommand(ctx, args, &stdout, &stderr, RetryPolicy{})
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// Probe the live state of the given repositories in parallel, see ExecuteInRepos
+// The state of each repository is stored in the State field of its result
+func ProbeInRepos(ctx context.Context, repos []Repo, opts ExecOptions) []*RepoResult {
+	opts.Output = OutputBuffered
+	opts.ChangedOnly = false
+	opts.FailFast = false // A repository whose state cannot be read must not stop the others
+	return ExecuteInRepos(ctx, repos, opts, func(*Repo) []string {
+		return []string{"--no-optional-locks", "status", "--porcelain=v2", "--branch"}
+	}, func(ctx context.Context, res *RepoResult, stdout, stderr io.Writer) {
+		res.State, res.Err = res.Repo.ProbeState(ctx)
+		if res.Err != nil {
+			fmt.Fprintln(stderr, res.Err)
+		}
+	}, func(*RepoResult) {})
+}
+
+// Format the age of a date in a short form, e.g. 5m, 3h, 2d, 4mo, 1y
+func FormatAge(t time.Time) string {
+	age := time.Since(t)
+	switch {
+	case age < time.Minute:
+		return "now"
+	case age < time.Hour:
+		return fmt.Sprintf("%dm", int(age.Minutes()))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(age.Hours()))
+	case age < 30*24*time.Hour:
+		return fmt.Sprintf("%dd", int(age.Hours()/24))
+	case age < 365*24*time.Hour:
+		return fmt.Sprintf("%dmo", int(age.Hours()/24/30))
+	}
+	return fmt.Sprintf("%dy", int(age.Hours()/24/365))
+}
+
+// Print the state of the repositories as an aligned table
+func PrintStateTable(results []*RepoResult) {
+	header := []string{"Repository", "Branch", "Upstream", "Ahead", "Behind", "Staged", "Unstaged", "Untracked", "Stashes", "Operation", "Last commit"}
+	type cell struct {
+		text  string
+		color string
+	}
+	var rows [][]cell
+
+	count := func(n int, color string) cell {
+		if n == 0 {
+			return cell{"0", ""}
+		}
+		return cell{strconv.Itoa(n), color}
+	}
+	for _, res := range results {
+		row := []cell{{res.Repo.Name, ColorCyan}}
+		state := res.State
+		switch {
+		case res.Status == StatusSkipped:
+			row = append(row, cell{"skipped", ColorYellow})
+		case res.Err != nil:
+			row = append(row, cell{res.Status, ColorRed})
+		case state.Missing:
+			row = append(row, cell{"missing", ColorRed})
+		default:
+			branch := cell{state.Branch, ColorGreen}
+			if state.Detached {
+				branch = cell{"(detached)", ColorYellow}
+			}
+			upstream := cell{state.Upstream, ""}
+			if state.Upstream == "" {
+				upstream = cell{"-", ColorYellow}
+			}
+			operation := cell{"-", ""}
+			if state.Operation != "" {
+				operation = cell{state.Operation, ColorRed}
+			}
+			lastCommit := cell{"-", ""}
+			if !state.LastCommit.IsZero() {
+				lastCommit = cell{FormatAge(state.LastCommit), ""}
+			}
+			unstaged := count(state.Unstaged, ColorYellow)
+			if state.Conflicts > 0 {
+				unstaged = cell{fmt.Sprintf("%d (%d conflicts)", state.Unstaged+state.Conflicts, state.Conflicts), ColorRed}
+			}
+			row = append(row, branch, upstream,
+				count(state.Ahead, ColorYellow), count(state.Behind, ColorYellow),
+				count(state.Staged, ColorYellow), unstaged, count(state.Untracked, ColorYellow),
+				count(state.Stashes, ColorYellow), operation, lastCommit)
+		}
+		rows = append(rows, row)
+	}
+
+	widths := make([]int, len(header))
+	for i, title := range header {
+		widths[i] = len(title)
+	}
+	for _, row := range rows {
+		for i, c := range row {
+			if len(c.text) > widths[i] {
+				widths[i] = len(c.text)
+			}
+		}
+	}
+
+	var line strings.Builder
+	for i, title := range header {
+		line.WriteString(fmt.Sprintf("%-*s  ", widths[i], title))
+	}
+	fmt.Println(ColorOutput(ColorCyan, strings.TrimRight(line.String(), " ")))
+	for _, row := range rows {
+		line.Reset()
+		for i, c := range row {
+			text := fmt.Sprintf("%-*s", widths[i], c.text)
+			if c.color != "" {
+				text = ColorOutput(c.color, text)
+			}
+			line.WriteString(text + "  ")
+		}
+		fmt.Println(strings.TrimRight(line.String(), " "))
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sync"
+)
+
+// Colors used to prefix the lines of the repositories in stream mode
+// Red is left out, it is reserved for errors
+var streamColors = []string{ColorCyan, ColorGreen, ColorYellow, ColorBlue, ColorMagenta}
+
+// Return the color of a repository in stream mode
+// The color only depends on the name, so a repository keeps the same color across runs
+func RepoColor(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return streamColors[h.Sum32()%uint32(len(streamColors))]
+}
+
+// Return the prefix of the lines of a repository in stream mode
+// The name is padded to width so that the output of all repositories is aligned
+func StreamPrefix(name string, width int) string {
+	return ColorOutput(RepoColor(name), fmt.Sprintf("%-*s |", width, name)) + " "
+}
+
+// Struct PrefixWriter writes complete lines, prefixed with the repository name, to an
+// underlying writer shared by all the repositories
+// Partial lines are held back until their end is received or the writer is flushed.
+// A carriage return discards the text before it on the current line, so that the
+// progress output of git (e.g. git fetch) only shows its final state instead of
+// overwriting the lines of other repositories. A carriage return followed by a
+// newline ends the line as a newline alone would.
+type PrefixWriter struct {
+	out    io.Writer
+	mu     *sync.Mutex // Shared by all the writers of out, so that lines are never mixed
+	prefix string
+	line   []byte
+	cr     bool // The last byte was a carriage return, kept across calls to Write
+}
+
+// Create a PrefixWriter
+func NewPrefixWriter(out io.Writer, mu *sync.Mutex, prefix string) *PrefixWriter {
+	return &PrefixWriter{out: out, mu: mu, prefix: prefix}
+}
+
+// Write implements io.Writer
+func (w *PrefixWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		// The line is only discarded once the byte after the carriage return
+		// is known, as it may be the end of a CRLF line
+		if w.cr && b != '\n' {
+			w.line = w.line[:0]
+		}
+		w.cr = b == '\r'
+		switch b {
+		case '\n':
+			w.emit()
+		case '\r':
+		default:
+			w.line = append(w.line, b)
+		}
+	}
+	return len(p), nil
+}
+
+// Write the pending partial line, if any
+// A trailing carriage return keeps the line, which is the final state of the progress.
+func (w *PrefixWriter) Flush() {
+	w.cr = false
+	if len(w.line) > 0 {
+		w.emit()
+	}
+}
+
+// Write the current line with its prefix and start a new one
+func (w *PrefixWriter) emit() {
+	var buf bytes.Buffer
+	buf.WriteString(w.prefix)
+	buf.Write(w.line)
+	buf.WriteByte('\n')
+	w.line = w.line[:0]
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.out.Write(buf.Bytes())
+}